@@ -5,16 +5,37 @@ import (
 	"crypto/rand"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dop251/goja"
 	"go.k6.io/k6/js/modules"
 	k6HTTP "go.k6.io/k6/js/modules/k6/http"
+	"go.k6.io/k6/lib"
 	"go.k6.io/k6/metrics"
 )
 
 type Options struct {
 	Propagator string
+
+	// HeaderMode is what to do with a trace header that was already set by the
+	// script, one of the HeaderMode* constants. It defaults to override.
+	HeaderMode string
+
+	// PromotedTags are the fields of the span context, like sampled, that are
+	// added to the tags of the request's samples, besides their metadata.
+	PromotedTags []string
+
+	// TracedRequestsMetric, if set, is the counter of the traced requests.
+	TracedRequestsMetric *metrics.Metric
+
+	// LogFailures, if set, logs the traced HTTP requests that failed.
+	LogFailures *FailureLog
+
+	// AfterRequest, if set, is called in the VU context after every traced
+	// request.
+	AfterRequest func()
 }
 
 type TracingClient struct {
@@ -22,11 +43,21 @@ type TracingClient struct {
 	httpRequest HttpRequestFunc
 
 	options Options
+	trails  *trailHolder
 }
 
 type HTTPResponse struct {
 	*k6HTTP.Response `js:"-"`
-	TraceID          string
+	TraceID          string `js:"trace_id"`
+
+	// Trace is the context of the request's span, and the headers that
+	// propagated it.
+	Trace map[string]interface{} `js:"trace"`
+
+	// ServerTraceID and ServerSpanID are the trace context the server
+	// returned, if it did, in a traceresponse or Server-Timing header.
+	ServerTraceID string `js:"serverTraceId"`
+	ServerSpanID  string `js:"serverSpanId"`
 }
 
 type (
@@ -77,28 +108,103 @@ func (c *TracingClient) Options(url goja.Value, args ...goja.Value) (*HTTPRespon
 	return c.WithTrace(requestToHttpFunc(http.MethodOptions, c.httpRequest), "HTTP OPTIONS", url, args...)
 }
 
-func isNilly(val goja.Value) bool {
+// IsNilly returns whether a JS value is missing, null or undefined.
+func IsNilly(val goja.Value) bool {
 	return val == nil || goja.IsNull(val) || goja.IsUndefined(val)
 }
 
-func (c *TracingClient) WithTrace(fn HttpFunc, spanName string, url goja.Value, args ...goja.Value) (*HTTPResponse, error) {
-	state := c.vu.State()
-	if state == nil {
-		return nil, fmt.Errorf("HTTP requests can only be made in the VU context")
+// mergeHeaders sets the given headers on a JS headers object. The keys are
+// matched case-insensitively, so a header that's already there in a different
+// case, e.g. Traceparent instead of traceparent, is replaced instead of being
+// sent twice with conflicting values. Multiple values of a header are joined
+// into a comma-separated list, which is how k6 sends them anyway.
+func mergeHeaders(headers *goja.Object, newHeaders http.Header) error {
+	for name, values := range newHeaders {
+		if len(values) == 0 {
+			continue
+		}
+		key := name
+		found := false
+		for _, existing := range headers.Keys() {
+			if !strings.EqualFold(existing, name) {
+				continue
+			}
+			if !found {
+				// The first key the script used is kept, with its casing.
+				key, found = existing, true
+				continue
+			}
+			if err := headers.Delete(existing); err != nil {
+				return err
+			}
+		}
+		if err := headers.Set(key, strings.Join(values, ", ")); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	traceID, err := Encode(TraceID{
-		Prefix: K6Prefix,
-		Code:   K6CloudCode,
-		Time:   time.Now(),
-	}, rand.Reader)
-	if err != nil {
-		return nil, err
+// findHeader looks up a header in a JS headers object, case-insensitively. It
+// returns its first value, if there are several.
+func findHeader(headers *goja.Object, name string) (string, bool) {
+	for _, key := range headers.Keys() {
+		if !strings.EqualFold(key, name) {
+			continue
+		}
+		val := headers.Get(key)
+		if values, ok := val.Export().([]interface{}); ok {
+			if len(values) == 0 {
+				return "", true
+			}
+			return fmt.Sprint(values[0]), true
+		}
+		return val.String(), true
 	}
+	return "", false
+}
 
-	tracingHeaders, err := GenerateHeaderBasedOnPropagator(c.options.Propagator, traceID)
-	if err != nil {
-		return nil, err
+// bodySize returns the size in bytes of a request body, if it can be known
+// before the request is made. Bodies that k6 still has to encode, like form
+// objects, are skipped.
+func bodySize(body goja.Value) (int, bool) {
+	if IsNilly(body) {
+		return 0, false
+	}
+	switch b := body.Export().(type) {
+	case string:
+		return len(b), true
+	case []byte:
+		return len(b), true
+	case goja.ArrayBuffer:
+		return len(b.Bytes()), true
+	default:
+		return 0, false
+	}
+}
+
+// responseSize returns the size of a response's body, which is only read when
+// the response type isn't none, or else of its Content-Length.
+func responseSize(res *k6HTTP.Response) (int, bool) {
+	switch b := res.Body.(type) {
+	case string:
+		return len(b), true
+	case []byte:
+		return len(b), true
+	}
+	for key, val := range res.Headers {
+		if strings.EqualFold(key, "Content-Length") {
+			size, err := strconv.Atoi(val)
+			return size, err == nil
+		}
+	}
+	return 0, false
+}
+
+func (c *TracingClient) WithTrace(fn HttpFunc, spanName string, url goja.Value, args ...goja.Value) (*HTTPResponse, error) {
+	state := c.vu.State()
+	if state == nil {
+		return nil, fmt.Errorf("HTTP requests can only be made in the VU context")
 	}
 
 	// This makes sure that the tracing header will always be added correctly to
@@ -118,7 +224,7 @@ func (c *TracingClient) WithTrace(fn HttpFunc, spanName string, url goja.Value,
 		}
 	} else {
 		jsParams := args[1]
-		if isNilly(jsParams) {
+		if IsNilly(jsParams) {
 			params = rt.NewObject()
 			args[1] = params
 		} else {
@@ -127,26 +233,123 @@ func (c *TracingClient) WithTrace(fn HttpFunc, spanName string, url goja.Value,
 	}
 	// Then we either augment the existing params.headers or create them:
 	var headers *goja.Object
-	if jsHeaders := params.Get("headers"); isNilly(jsHeaders) {
+	if jsHeaders := params.Get("headers"); IsNilly(jsHeaders) {
 		headers = rt.NewObject()
 		params.Set("headers", headers)
 	} else {
 		headers = jsHeaders.ToObject(rt)
 	}
-	for key, val := range tracingHeaders {
-		headers.Set(key, val)
+
+	// Depending on the header mode, a trace header set by the user is either
+	// replaced, kept, or the parent of the request's span.
+	headerName, err := HeaderNameForPropagator(c.options.Propagator)
+	if err != nil {
+		return nil, err
+	}
+	userHeader, hasUserHeader := findHeader(headers, headerName)
+	span, tracingHeaders, err := newRequestSpan(c.options, userHeader, hasUserHeader)
+	if err != nil {
+		return nil, err
+	}
+	traceID, spanID := span.TraceID, span.SpanID
+	if err := mergeHeaders(headers, tracingHeaders); err != nil {
+		return nil, err
 	}
 
-	// TODO: set span_id as well as some other metadata?
-	state.Tags.Modify(func(tagsAndMeta *metrics.TagsAndMeta) {
-		tagsAndMeta.SetMetadata("trace_id", traceID)
-	})
-	defer state.Tags.Modify(func(tagsAndMeta *metrics.TagsAndMeta) {
-		tagsAndMeta.DeleteMetadata("trace_id")
-	})
+	// The call ID tells the hops of a redirect chain, which share the span's
+	// metadata, from separate calls, which may send the same preserved header.
+	metadata := spanMetadata(span)
+	metadata["call_id"] = RandHexStringRunes(SpanIDSize)
+	if len(args) > 0 {
+		if size, ok := bodySize(args[0]); ok {
+			metadata["request_bytes"] = strconv.Itoa(size)
+		}
+	}
+	defer setTraceMetadata(state, metadata)()
+	defer setTraceTags(state, promotedTags(c.options.PromotedTags, span))()
+
+	// The trails are held back until the response is parsed, so they can
+	// link to the span the server returned.
+	trails := c.holdTrails(state)
+	defer trails.release()
 
 	// This calls the actual request() function from k6/http with our augmented arguments
 	res, e := fn(c.vu.Context(), url, args...)
+	countTracedRequest(c.vu, state, c.options.TracedRequestsMetric)
+	response := &HTTPResponse{
+		Response: res,
+		TraceID:  traceID,
+		Trace:    newTraceObject(span, sentTraceHeaders(headerName, userHeader, tracingHeaders)),
+	}
+	if res != nil {
+		if size, ok := responseSize(res); ok {
+			trails.Metadata["response_bytes"] = strconv.Itoa(size)
+		}
+		if server, ok := ParseServerTraceContext(res.Headers); ok {
+			response.ServerTraceID, response.ServerSpanID = server.TraceID, server.SpanID
+			if server.TraceID != traceID || server.SpanID != spanID {
+				trails.Metadata["link_trace_id"] = server.TraceID
+				trails.Metadata["link_span_id"] = server.SpanID
+			}
+		}
+	}
+	trails.release()
+	if c.options.LogFailures != nil && requestFailed(res, e, trails.unexpected()) {
+		c.options.LogFailures.logFailure(state, span, url.String(), res, e)
+	}
+	if c.options.AfterRequest != nil {
+		c.options.AfterRequest()
+	}
 
-	return &HTTPResponse{Response: res, TraceID: traceID}, e
+	return response, e
+}
+
+// holdTrails holds back the trails of a call. The holder's goroutine is
+// started once per VU context, rather than for every call.
+func (c *TracingClient) holdTrails(state *lib.State) *heldTrails {
+	if c.trails == nil || !c.trails.usable(c.vu.Context(), state.Samples) {
+		c.trails = newTrailHolder(c.vu.Context(), state.Samples)
+	}
+	return c.trails.hold(state)
+}
+
+// newTraceAndSpanID generates the IDs of a new k6 trace and its root span.
+func newTraceAndSpanID() (string, string, error) {
+	traceID, err := Encode(TraceID{
+		Prefix: K6Prefix,
+		Code:   K6CloudCode,
+		Time:   time.Now(),
+	}, rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return traceID, RandHexStringRunes(SpanIDSize), nil
+}
+
+// setTraceMetadata adds the given metadata, along with the VU and iteration
+// numbers, to the samples the VU emits until the returned function is called.
+func setTraceMetadata(state *lib.State, metadata map[string]string) func() {
+	// The vu and iter values may already be there if they were enabled as
+	// system tags, in which case we should leave them alone.
+	optionalMetadata := map[string]string{
+		"vu":   strconv.FormatUint(state.VUID, 10),
+		"iter": strconv.FormatInt(state.Iteration, 10),
+	}
+	state.Tags.Modify(func(tagsAndMeta *metrics.TagsAndMeta) {
+		for key, val := range optionalMetadata {
+			if _, exists := tagsAndMeta.Metadata[key]; !exists {
+				metadata[key] = val
+			}
+		}
+		for key, val := range metadata {
+			tagsAndMeta.SetMetadata(key, val)
+		}
+	})
+	return func() {
+		state.Tags.Modify(func(tagsAndMeta *metrics.TagsAndMeta) {
+			for key := range metadata {
+				tagsAndMeta.DeleteMetadata(key)
+			}
+		})
+	}
 }
@@ -0,0 +1,519 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/oxtoacart/bpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/guregu/null.v3"
+
+	k6HTTP "go.k6.io/k6/js/modules/k6/http"
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/netext/httpext"
+	"go.k6.io/k6/lib/testutils"
+	"go.k6.io/k6/lib/testutils/httpmultibin"
+	"go.k6.io/k6/metrics"
+)
+
+type testHTTPClient struct {
+	rt       *modulestest.Runtime
+	tb       *httpmultibin.HTTPMultiBin
+	registry *metrics.Registry
+	samples  chan metrics.SampleContainer
+}
+
+// newTestHTTPClient sets up a VU with a traced HTTP client, available to the
+// scripts as `http`, and a local HTTP server.
+func newTestHTTPClient(t *testing.T, options Options) *testHTTPClient {
+	t.Helper()
+
+	tb := httpmultibin.NewHTTPMultiBin(t)
+	rt := modulestest.NewRuntime(t)
+	var requestFunc HttpRequestFunc
+	request := k6HTTP.New().NewModuleInstance(rt.VU).Exports().Default.(*goja.Object).Get("request")
+	require.NoError(t, rt.VU.Runtime().ExportTo(request, &requestFunc))
+	require.NoError(t, rt.VU.Runtime().Set("http", New(rt.VU, requestFunc, options)))
+
+	registry := metrics.NewRegistry()
+	root, err := lib.NewGroup("", nil)
+	require.NoError(t, err)
+	samples := make(chan metrics.SampleContainer, 1000)
+	rt.MoveToVUContext(&lib.State{
+		Group:     root,
+		Dialer:    tb.Dialer,
+		TLSConfig: tb.TLSClientConfig,
+		Transport: tb.HTTPTransport,
+		BPool:     bpool.NewBufferPool(1),
+		Samples:   samples,
+		Options: lib.Options{
+			UserAgent:    null.StringFrom("k6-test"),
+			MaxRedirects: null.IntFrom(10),
+			SystemTags:   &metrics.DefaultSystemTagSet,
+		},
+		BuiltinMetrics: metrics.RegisterBuiltinMetrics(registry),
+		Tags:           lib.NewVUStateTags(registry.RootTagSet()),
+		Logger:         testutils.NewLogger(t),
+	})
+	return &testHTTPClient{rt: rt, tb: tb, registry: registry, samples: samples}
+}
+
+func (c *testHTTPClient) run(t *testing.T, script string) goja.Value {
+	t.Helper()
+	val, err := c.rt.VU.Runtime().RunString(c.tb.Replacer.Replace(script))
+	require.NoError(t, err)
+	return val
+}
+
+func (c *testHTTPClient) collect() []metrics.Sample {
+	close(c.samples)
+	var samples []metrics.Sample
+	for container := range c.samples {
+		samples = append(samples, container.GetSamples()...)
+	}
+	return samples
+}
+
+// collectTrails returns the trails of the requests the VU made.
+func (c *testHTTPClient) collectTrails() []*httpext.Trail {
+	close(c.samples)
+	var trails []*httpext.Trail
+	for container := range c.samples {
+		if trail, ok := container.(*httpext.Trail); ok {
+			trails = append(trails, trail)
+		}
+	}
+	return trails
+}
+
+func TestServerTraceContextFromResponse(t *testing.T) {
+	t.Parallel()
+
+	const serverTraceID = "fedcba9876543210fedcba9876543210"
+	const serverSpanID = "fedcba9876543210"
+
+	tests := map[string]http.Header{
+		"traceresponse": {"Traceresponse": {"00-" + serverTraceID + "-" + serverSpanID + "-01"}},
+		"server-timing": {"Server-Timing": {`cache;desc="hit", traceparent;desc="00-` + serverTraceID + "-" + serverSpanID + `-01"`}},
+	}
+	for name, headers := range tests {
+		headers := headers
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := newTestHTTPClient(t, Options{Propagator: PropagatorW3C})
+			c.tb.Mux.HandleFunc("/traced", func(w http.ResponseWriter, r *http.Request) {
+				for key, vals := range headers {
+					w.Header()[key] = vals
+				}
+			})
+
+			val := c.run(t, `
+				var res = http.get("HTTPBIN_URL/redirect-to?url=/traced");
+				[res.trace_id, res.serverTraceId, res.serverSpanId];
+			`)
+			var ids []string
+			require.NoError(t, c.rt.VU.Runtime().ExportTo(val, &ids))
+			assert.Equal(t, serverTraceID, ids[1])
+			assert.Equal(t, serverSpanID, ids[2])
+
+			// Only the last hop, whose response had the server's trace
+			// context, links to its span.
+			trails := c.collectTrails()
+			require.Len(t, trails, 2)
+			assert.Empty(t, trails[0].Metadata["link_trace_id"])
+			assert.Equal(t, ids[0], trails[1].Metadata["trace_id"])
+			assert.Equal(t, serverTraceID, trails[1].Metadata["link_trace_id"])
+			assert.Equal(t, serverSpanID, trails[1].Metadata["link_span_id"])
+		})
+	}
+}
+
+func TestResponseBytesAreAddedToTheTrail(t *testing.T) {
+	t.Parallel()
+
+	c := newTestHTTPClient(t, Options{Propagator: PropagatorW3C})
+	c.run(t, `
+		http.get("HTTPBIN_URL/bytes/100");
+		http.get("HTTPBIN_URL/bytes/200", { responseType: "none" });
+		http.post("HTTPBIN_URL/post", "hello");
+	`)
+
+	trails := c.collectTrails()
+	require.Len(t, trails, 3)
+	assert.Equal(t, "100", trails[0].Metadata["response_bytes"])
+	// Without a body, the size is taken from the Content-Length.
+	assert.Equal(t, "200", trails[1].Metadata["response_bytes"])
+	assert.Equal(t, "5", trails[2].Metadata["request_bytes"])
+	assert.NotEmpty(t, trails[2].Metadata["response_bytes"])
+}
+
+func TestNoServerTraceContextInResponse(t *testing.T) {
+	t.Parallel()
+
+	c := newTestHTTPClient(t, Options{Propagator: PropagatorW3C})
+	val := c.run(t, `
+		var res = http.get("HTTPBIN_URL/get");
+		res.serverTraceId;
+	`)
+	assert.Equal(t, "", val.String())
+}
+
+func TestHeaderModes(t *testing.T) {
+	t.Parallel()
+
+	const upstreamTraceID = "fedcba9876543210fedcba9876543210"
+	const upstreamSpanID = "fedcba9876543210"
+
+	tests := []struct {
+		propagator string
+		mode       string
+		header     string
+		value      string
+		check      func(t *testing.T, sent string, span map[string]string)
+	}{
+		{
+			propagator: PropagatorW3C,
+			mode:       HeaderModeOverride,
+			header:     "traceparent",
+			value:      "00-" + upstreamTraceID + "-" + upstreamSpanID + "-01",
+			check: func(t *testing.T, sent string, span map[string]string) {
+				assert.NotEqual(t, upstreamTraceID, span["trace_id"])
+				assert.Equal(t, "00-"+span["trace_id"]+"-"+span["span_id"]+"-01", sent)
+				assert.Empty(t, span["parent_span_id"])
+			},
+		},
+		{
+			propagator: PropagatorW3C,
+			mode:       HeaderModePreserve,
+			header:     "Traceparent",
+			value:      "00-" + upstreamTraceID + "-" + upstreamSpanID + "-01",
+			check: func(t *testing.T, sent string, span map[string]string) {
+				assert.Equal(t, "00-"+upstreamTraceID+"-"+upstreamSpanID+"-01", sent)
+				assert.Equal(t, upstreamTraceID, span["trace_id"])
+				assert.Equal(t, upstreamSpanID, span["parent_span_id"])
+				assert.Regexp(t, "^[0-9a-f]{16}$", span["span_id"])
+				assert.NotEqual(t, upstreamSpanID, span["span_id"])
+			},
+		},
+		{
+			propagator: PropagatorW3C,
+			mode:       HeaderModeChild,
+			header:     "traceparent",
+			value:      "00-" + upstreamTraceID + "-" + upstreamSpanID + "-01",
+			check: func(t *testing.T, sent string, span map[string]string) {
+				assert.Equal(t, upstreamTraceID, span["trace_id"])
+				assert.Equal(t, upstreamSpanID, span["parent_span_id"])
+				assert.NotEqual(t, upstreamSpanID, span["span_id"])
+				assert.Equal(t, "00-"+upstreamTraceID+"-"+span["span_id"]+"-01", sent)
+			},
+		},
+		{
+			propagator: PropagatorB3,
+			mode:       HeaderModeChild,
+			header:     "b3",
+			value:      upstreamTraceID + "-" + upstreamSpanID + "-1",
+			check: func(t *testing.T, sent string, span map[string]string) {
+				assert.Equal(t, upstreamTraceID, span["trace_id"])
+				assert.Equal(t, upstreamSpanID, span["parent_span_id"])
+				assert.Equal(t, upstreamTraceID+"-"+span["span_id"]+"-1", sent)
+			},
+		},
+		{
+			propagator: PropagatorJaeger,
+			mode:       HeaderModePreserve,
+			header:     "Uber-Trace-Id",
+			value:      upstreamTraceID + ":" + upstreamSpanID + ":0:1",
+			check: func(t *testing.T, sent string, span map[string]string) {
+				assert.Equal(t, upstreamTraceID+":"+upstreamSpanID+":0:1", sent)
+				assert.Equal(t, upstreamTraceID, span["trace_id"])
+				assert.Equal(t, upstreamSpanID, span["parent_span_id"])
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.propagator+"/"+tt.mode, func(t *testing.T) {
+			t.Parallel()
+
+			c := newTestHTTPClient(t, Options{Propagator: tt.propagator, HeaderMode: tt.mode})
+			sent := make(chan []string, 1)
+			headerName, err := HeaderNameForPropagator(tt.propagator)
+			require.NoError(t, err)
+			c.tb.Mux.HandleFunc("/traced", func(w http.ResponseWriter, r *http.Request) {
+				sent <- r.Header.Values(headerName)
+			})
+
+			val := c.run(t, `
+				var res = http.get("HTTPBIN_URL/traced", {headers: {"`+tt.header+`": "`+tt.value+`"}});
+				res.trace_id;
+			`)
+			values := <-sent
+			require.Len(t, values, 1, "there should be a single trace header")
+
+			var span map[string]string
+			for _, sample := range c.collect() {
+				if sample.Metric.Name == metrics.HTTPReqsName {
+					span = sample.Metadata
+				}
+			}
+			require.NotNil(t, span)
+			assert.Equal(t, span["trace_id"], val.String())
+			tt.check(t, values[0], span)
+		})
+	}
+}
+
+func TestPreservedHeaderSentTwice(t *testing.T) {
+	t.Parallel()
+
+	const upstream = "00-fedcba9876543210fedcba9876543210-fedcba9876543210-01"
+	c := newTestHTTPClient(t, Options{Propagator: PropagatorW3C, HeaderMode: HeaderModePreserve})
+	sent := make(chan string, 2)
+	c.tb.Mux.HandleFunc("/traced", func(w http.ResponseWriter, r *http.Request) {
+		sent <- r.Header.Get(HeaderNameW3C)
+	})
+
+	c.run(t, `
+		var params = {headers: {"traceparent": "`+upstream+`"}};
+		http.get("HTTPBIN_URL/traced", params);
+		http.get("HTTPBIN_URL/traced", params);
+	`)
+	assert.Equal(t, upstream, <-sent)
+	assert.Equal(t, upstream, <-sent)
+
+	trails := c.collectTrails()
+	require.Len(t, trails, 2)
+	first, second := trails[0].Metadata, trails[1].Metadata
+	assert.Equal(t, "fedcba9876543210", first["parent_span_id"])
+	assert.Equal(t, "fedcba9876543210", second["parent_span_id"])
+	assert.NotEqual(t, first["span_id"], second["span_id"], "each call should have its own span")
+	assert.NotEmpty(t, first["call_id"])
+	assert.NotEqual(t, first["call_id"], second["call_id"])
+}
+
+func TestHeaderModeWithInvalidHeader(t *testing.T) {
+	t.Parallel()
+
+	c := newTestHTTPClient(t, Options{Propagator: PropagatorW3C, HeaderMode: HeaderModeChild})
+	_, err := c.rt.VU.Runtime().RunString(c.tb.Replacer.Replace(`
+		http.get("HTTPBIN_URL/get", {headers: {"traceparent": "garbage"}});
+	`))
+	assert.ErrorContains(t, err, "can't use the w3c trace header of the request in the child header mode")
+}
+
+func TestHeadersAreMergedCaseInsensitively(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		propagator string
+		headers    string
+		check      func(t *testing.T, headers http.Header, traceID string)
+	}{
+		{
+			name:       "Lowercase",
+			propagator: PropagatorW3C,
+			headers:    `{"traceparent": "00-fedcba9876543210fedcba9876543210-fedcba9876543210-01"}`,
+			check: func(t *testing.T, headers http.Header, traceID string) {
+				require.Len(t, headers.Values("Traceparent"), 1)
+				assert.Contains(t, headers.Get("Traceparent"), traceID)
+			},
+		},
+		{
+			name:       "Uppercase",
+			propagator: PropagatorW3C,
+			headers:    `{"TRACEPARENT": "00-fedcba9876543210fedcba9876543210-fedcba9876543210-01"}`,
+			check: func(t *testing.T, headers http.Header, traceID string) {
+				require.Len(t, headers.Values("Traceparent"), 1)
+				assert.Contains(t, headers.Get("Traceparent"), traceID)
+			},
+		},
+		{
+			name:       "SeveralCases",
+			propagator: PropagatorJaeger,
+			headers:    `{"uber-trace-id": "a:b:0:1", "Uber-Trace-Id": "c:d:0:1", "X-Other": "kept"}`,
+			check: func(t *testing.T, headers http.Header, traceID string) {
+				require.Len(t, headers.Values("Uber-Trace-Id"), 1)
+				assert.Regexp(t, "^"+traceID+":[0-9a-f]{16}:0:1$", headers.Get("Uber-Trace-Id"))
+				assert.Equal(t, "kept", headers.Get("X-Other"))
+			},
+		},
+		{
+			name:       "MultiValue",
+			propagator: PropagatorB3,
+			headers:    `{"B3": ["1", "0"], "X-Multi": ["a", "b"]}`,
+			check: func(t *testing.T, headers http.Header, traceID string) {
+				require.Len(t, headers.Values("B3"), 1)
+				assert.Regexp(t, "^"+traceID+"-[0-9a-f]{16}-1$", headers.Get("B3"))
+				assert.Equal(t, "a,b", headers.Get("X-Multi"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := newTestHTTPClient(t, Options{Propagator: tt.propagator})
+			received := make(chan http.Header, 1)
+			c.tb.Mux.HandleFunc("/echo-headers", func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Header.Clone()
+			})
+
+			val := c.run(t, `
+				var params = {headers: `+tt.headers+`};
+				var res = http.get("HTTPBIN_URL/echo-headers", params);
+				res.trace_id;
+			`)
+			tt.check(t, <-received, val.String())
+		})
+	}
+}
+
+func TestMergeHeaders(t *testing.T) {
+	t.Parallel()
+
+	rt := modulestest.NewRuntime(t).VU.Runtime()
+	headers, err := rt.RunString(`({"TraceState": "old", "Accept": "*/*"})`)
+	require.NoError(t, err)
+	obj := headers.ToObject(rt)
+
+	require.NoError(t, mergeHeaders(obj, http.Header{
+		"tracestate": {"k6=1", "vendor=2"},
+		"baggage":    {"user=1"},
+	}))
+	assert.Equal(t, map[string]interface{}{
+		"TraceState": "k6=1, vendor=2",
+		"Accept":     "*/*",
+		"baggage":    "user=1",
+	}, obj.Export())
+}
+
+func TestResponseTraceObject(t *testing.T) {
+	t.Parallel()
+
+	mappers := map[string]goja.FieldNameMapper{
+		"k6":    nil, // the one of the modulestest runtime
+		"uncap": goja.UncapFieldNameMapper(),
+	}
+	for name, mapper := range mappers {
+		mapper := mapper
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := newTestHTTPClient(t, Options{Propagator: PropagatorW3C, HeaderMode: HeaderModeChild})
+			if mapper != nil {
+				c.rt.VU.Runtime().SetFieldNameMapper(mapper)
+			}
+			received := make(chan string, 1)
+			c.tb.Mux.HandleFunc("/traced", func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Header.Get(HeaderNameW3C)
+			})
+
+			val := c.run(t, `
+				var res = http.get("HTTPBIN_URL/traced", {headers: {
+					traceparent: "00-fedcba9876543210fedcba9876543210-fedcba9876543210-00",
+				}});
+				JSON.stringify(res.trace);
+			`)
+			var trace map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(val.String()), &trace))
+
+			spanID, ok := trace["spanId"].(string)
+			require.True(t, ok)
+			sent := "00-fedcba9876543210fedcba9876543210-" + spanID + "-00"
+			assert.Equal(t, map[string]interface{}{
+				"traceId":        "fedcba9876543210fedcba9876543210",
+				"spanId":         spanID,
+				"parentSpanId":   "fedcba9876543210",
+				"trace_id":       "fedcba9876543210fedcba9876543210",
+				"span_id":        spanID,
+				"parent_span_id": "fedcba9876543210",
+				"sampled":        false,
+				"propagator":     PropagatorW3C,
+				"headers":        map[string]interface{}{HeaderNameW3C: sent},
+			}, trace)
+			assert.Equal(t, sent, <-received)
+		})
+	}
+}
+
+func TestResponseTraceIDNames(t *testing.T) {
+	t.Parallel()
+
+	c := newTestHTTPClient(t, Options{Propagator: PropagatorB3})
+	val := c.run(t, `
+		var res = http.get("HTTPBIN_URL/get");
+		if (res.trace_id !== res.trace.traceId || res.trace.trace_id !== res.trace.traceId) {
+			throw new Error("the trace IDs don't match");
+		}
+		[res.trace.propagator, res.trace.sampled, res.trace.headers.b3];
+	`)
+	var got []interface{}
+	require.NoError(t, c.rt.VU.Runtime().ExportTo(val, &got))
+	assert.Equal(t, PropagatorB3, got[0])
+	assert.Equal(t, true, got[1])
+	assert.Regexp(t, "^[0-9a-f]{32}-[0-9a-f]{16}-1$", got[2])
+}
+
+func TestPromotedTagsAndTracedRequests(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	traced := registry.MustNewMetric("tracing_traced_requests", metrics.Counter)
+	c := newTestHTTPClient(t, Options{
+		Propagator:           PropagatorJaeger,
+		PromotedTags:         []string{PromotedTagSampled, PromotedTagPropagator},
+		TracedRequestsMetric: traced,
+	})
+	state := c.rt.VU.State()
+	state.Tags.Modify(func(tagsAndMeta *metrics.TagsAndMeta) {
+		tagsAndMeta.SetTag("propagator", "user")
+	})
+
+	c.run(t, `
+		http.get("HTTPBIN_URL/get");
+		http.get("HTTPBIN_URL/get");
+	`)
+
+	tags := state.Tags.GetCurrentValues().Tags
+	userTag, _ := tags.Get("propagator")
+	assert.Equal(t, "user", userTag, "the tags replaced during the requests should be restored")
+	_, hasSampled := tags.Get("sampled")
+	assert.False(t, hasSampled)
+
+	var requests, tracedRequests int
+	for _, sample := range c.collect() {
+		switch sample.Metric {
+		case traced:
+			tracedRequests++
+		default:
+			if sample.Metric.Name != metrics.HTTPReqsName {
+				continue
+			}
+			requests++
+		}
+		assert.Equal(t, map[string]string{
+			"sampled":    "true",
+			"propagator": PropagatorJaeger,
+		}, map[string]string{
+			"sampled":    sample.Tags.Map()["sampled"],
+			"propagator": sample.Tags.Map()["propagator"],
+		})
+		assert.NotContains(t, sample.Tags.Map(), "trace_id")
+	}
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 2, tracedRequests)
+}
+
+func TestValidatePromotedTags(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, ValidatePromotedTags([]string{PromotedTagTraceID, PromotedTagSampled, PromotedTagPropagator}))
+	assert.Error(t, ValidatePromotedTags([]string{"span_id"}))
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	k6HTTP "go.k6.io/k6/js/modules/k6/http"
+	"go.k6.io/k6/lib"
+)
+
+// FailureLog configures the logging of the traced requests that failed, so
+// their traces can be found without logging every trace ID from the script.
+type FailureLog struct {
+	Level logrus.Level
+
+	// TraceURL is an optional template of a link to the trace, in which
+	// {traceId} and {spanId} are replaced, e.g. https://tempo/trace/{traceId}.
+	TraceURL string
+
+	// Limiter is shared by all the VUs, so the logs aren't flooded when a
+	// system under test starts failing.
+	Limiter *LogLimiter
+}
+
+// LogLimiter is a token bucket rate limiter of log lines.
+type LogLimiter struct {
+	mu         sync.Mutex
+	perSecond  float64
+	burst      float64
+	tokens     float64
+	last       time.Time
+	suppressed int64
+	now        func() time.Time
+}
+
+// NewLogLimiter creates a limiter that allows perSecond lines on average, in
+// bursts of up to burst lines.
+func NewLogLimiter(perSecond float64, burst int) *LogLimiter {
+	return &LogLimiter{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		now:       time.Now,
+	}
+}
+
+// allow returns whether a line can be logged now and, if it can, how many
+// lines were suppressed since the last one that was logged.
+func (l *LogLimiter) allow() (bool, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.perSecond
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		l.suppressed++
+		return false, 0
+	}
+	l.tokens--
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// requestFailed tells whether a traced request failed: it couldn't be made,
+// k6 didn't expect its response, according to its response callback, or the
+// server failed with a 5xx status, whatever the callback expects.
+func requestFailed(res *k6HTTP.Response, err error, unexpected bool) bool {
+	if err != nil || res == nil {
+		return true
+	}
+	return res.Error != "" || unexpected || res.Status >= 500
+}
+
+// logFailure logs a failed traced request, if the rate limit allows it.
+func (f *FailureLog) logFailure(state *lib.State, span SpanContext, url string, res *k6HTTP.Response, err error) {
+	ok, suppressed := f.Limiter.allow()
+	if !ok {
+		return
+	}
+
+	fields := logrus.Fields{
+		"trace_id": span.TraceID,
+		"span_id":  span.SpanID,
+		"url":      url,
+		"vu":       state.VUID,
+		"iter":     state.Iteration,
+	}
+	if res != nil {
+		fields["status"] = res.Status
+		if res.URL != "" {
+			fields["url"] = res.URL
+		}
+		if res.Error != "" {
+			fields["error"] = res.Error
+		}
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	if f.TraceURL != "" {
+		fields["trace_url"] = strings.NewReplacer(
+			"{traceId}", span.TraceID,
+			"{spanId}", span.SpanID,
+		).Replace(f.TraceURL)
+	}
+	if suppressed > 0 {
+		fields["suppressed"] = suppressed
+	}
+	state.Logger.WithFields(fields).Log(f.Level, "Traced request failed")
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	k6HTTP "go.k6.io/k6/js/modules/k6/http"
+)
+
+func TestFailedRequestsAreLogged(t *testing.T) {
+	t.Parallel()
+
+	c := newTestHTTPClient(t, Options{
+		Propagator: PropagatorW3C,
+		LogFailures: &FailureLog{
+			Level:    logrus.WarnLevel,
+			TraceURL: "https://tempo/trace/{traceId}",
+			Limiter:  NewLogLimiter(1, 10),
+		},
+	})
+	logger, hook := logtest.NewNullLogger()
+	c.rt.VU.State().Logger = logger
+	k6http := k6HTTP.New().NewModuleInstance(c.rt.VU).Exports().Default.(*goja.Object)
+	require.NoError(t, c.rt.VU.Runtime().Set("expectedStatuses", k6http.Get("expectedStatuses")))
+
+	val := c.run(t, `
+		var failed = http.get("HTTPBIN_URL/status/503");
+		http.get("HTTPBIN_URL/status/200");
+		http.get("HTTPBIN_URL/status/404");
+		// The response callback of the request decides what's expected,
+		// except for 5xx statuses, which are always logged.
+		http.get("HTTPBIN_URL/status/404", {responseCallback: expectedStatuses(404)});
+		http.get("HTTPBIN_URL/status/201", {responseCallback: expectedStatuses(200)});
+		http.get("HTTPBIN_URL/status/502", {responseCallback: expectedStatuses(502)});
+		http.get("HTTPBIN_URL/status/404", {responseCallback: null});
+		http.get("http://127.0.0.1:1/refused");
+		failed.trace_id;
+	`)
+
+	// k6 logs the network error too.
+	var entries []*logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Traced request failed" {
+			entries = append(entries, entry)
+		}
+	}
+	require.Len(t, entries, 5)
+	entry := entries[0]
+	assert.Equal(t, logrus.WarnLevel, entry.Level)
+	assert.Equal(t, val.String(), entry.Data["trace_id"])
+	assert.Equal(t, 503, entry.Data["status"])
+	assert.Equal(t, c.tb.Replacer.Replace("HTTPBIN_URL/status/503"), entry.Data["url"])
+	assert.Equal(t, "https://tempo/trace/"+val.String(), entry.Data["trace_url"])
+	assert.Contains(t, entry.Data, "vu")
+	assert.Contains(t, entry.Data, "iter")
+	assert.Equal(t, 404, entries[1].Data["status"])
+	assert.Equal(t, 201, entries[2].Data["status"])
+	assert.Equal(t, 502, entries[3].Data["status"])
+	assert.Equal(t, 0, entries[4].Data["status"])
+	assert.Contains(t, entries[4].Data["error"], "connection refused")
+}
+
+func TestLogLimiter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000, 0)
+	l := NewLogLimiter(1, 2)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		ok, suppressed := l.allow()
+		assert.True(t, ok)
+		assert.Zero(t, suppressed)
+	}
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allow()
+		assert.False(t, ok)
+	}
+
+	now = now.Add(time.Second)
+	ok, suppressed := l.allow()
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), suppressed)
+	ok, _ = l.allow()
+	assert.False(t, ok)
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dop251/goja"
+	"go.k6.io/k6/js/modules"
+	k6grpc "go.k6.io/k6/js/modules/k6/grpc"
+	"go.k6.io/k6/lib/netext/grpcext"
+)
+
+// TracingGRPCClient wraps a k6/net/grpc Client, adding the trace context to
+// the metadata of every invoked RPC.
+type TracingGRPCClient struct {
+	vu     modules.VU
+	client *k6grpc.Client
+
+	options Options
+}
+
+type GRPCResponse struct {
+	*grpcext.Response `js:"-"`
+	TraceID           string                 `js:"trace_id"`
+	Trace             map[string]interface{} `js:"trace"`
+}
+
+func NewGRPC(vu modules.VU, grpcClient *k6grpc.Client, options Options) *TracingGRPCClient {
+	return &TracingGRPCClient{
+		vu:      vu,
+		client:  grpcClient,
+		options: options,
+	}
+}
+
+func (c *TracingGRPCClient) Load(importPaths []string, filenames ...string) ([]k6grpc.MethodInfo, error) {
+	return c.client.Load(importPaths, filenames...)
+}
+
+func (c *TracingGRPCClient) LoadProtoset(protosetPath string) ([]k6grpc.MethodInfo, error) {
+	return c.client.LoadProtoset(protosetPath)
+}
+
+func (c *TracingGRPCClient) Connect(addr string, params map[string]interface{}) (bool, error) {
+	return c.client.Connect(addr, params)
+}
+
+func (c *TracingGRPCClient) Close() error {
+	return c.client.Close()
+}
+
+// Invoke calls a unary RPC, like the k6/net/grpc Client's invoke(), with the
+// trace context in its metadata.
+func (c *TracingGRPCClient) Invoke(method string, req goja.Value, params goja.Value) (*GRPCResponse, error) {
+	state := c.vu.State()
+	if state == nil {
+		return nil, fmt.Errorf("gRPC methods can only be invoked in the VU context")
+	}
+
+	// The params are copied, so the metadata can be replaced without
+	// modifying the object of the script.
+	rt := c.vu.Runtime()
+	tracedParams := rt.NewObject()
+	userMetadata := make(map[string]interface{})
+	if !IsNilly(params) {
+		paramsObj := params.ToObject(rt)
+		for _, key := range paramsObj.Keys() {
+			if key != "metadata" && key != "headers" {
+				if err := tracedParams.Set(key, paramsObj.Get(key)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			exported, ok := paramsObj.Get(key).Export().(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("metadata must be an object with key-value pairs")
+			}
+			for mk, mv := range exported {
+				userMetadata[mk] = mv
+			}
+		}
+	}
+	headerName, err := HeaderNameForPropagator(c.options.Propagator)
+	if err != nil {
+		return nil, err
+	}
+	var userHeader string
+	var hasUserHeader bool
+	for key, val := range userMetadata {
+		if strings.EqualFold(key, headerName) {
+			userHeader, hasUserHeader = CarrierValue(val)
+		}
+	}
+	span, tracingHeaders, err := newRequestSpan(c.options, userHeader, hasUserHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	// The metadata is passed as a Go map, so binary values, like the one of
+	// grpc-trace-bin, don't go through a JS string conversion.
+	if err := tracedParams.Set("metadata", grpcTraceMetadata(userMetadata, tracingHeaders)); err != nil {
+		return nil, err
+	}
+
+	defer setTraceMetadata(state, spanMetadata(span))()
+	defer setTraceTags(state, promotedTags(c.options.PromotedTags, span))()
+
+	res, err := c.client.Invoke(method, req, tracedParams)
+	countTracedRequest(c.vu, state, c.options.TracedRequestsMetric)
+	if c.options.AfterRequest != nil {
+		c.options.AfterRequest()
+	}
+
+	return &GRPCResponse{
+		Response: res,
+		TraceID:  span.TraceID,
+		Trace:    newTraceObject(span, sentTraceHeaders(headerName, userHeader, tracingHeaders)),
+	}, err
+}
+
+// grpcTraceMetadata merges the tracing headers into the metadata of an RPC.
+// gRPC metadata keys are always lowercase. k6 only takes string values, so
+// binary ones, like a grpc-trace-bin ArrayBuffer, are converted to strings of
+// their bytes, and any other value is left for k6 to reject.
+func grpcTraceMetadata(metadata map[string]interface{}, tracingHeaders http.Header) map[string]interface{} {
+	merged := make(map[string]interface{}, len(metadata)+len(tracingHeaders))
+	for key, val := range metadata {
+		if str, ok := CarrierValue(val); ok {
+			merged[strings.ToLower(key)] = str
+			continue
+		}
+		merged[strings.ToLower(key)] = val
+	}
+	for key, vals := range tracingHeaders {
+		if len(vals) > 0 {
+			merged[strings.ToLower(key)] = vals[0]
+		}
+	}
+	return merged
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/test/grpc_testing"
+	"gopkg.in/guregu/null.v3"
+
+	k6grpc "go.k6.io/k6/js/modules/k6/grpc"
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/testutils/httpmultibin"
+	"go.k6.io/k6/metrics"
+)
+
+// grpcTest is a traced gRPC client in a VU, calling a server that records the
+// received metadata.
+type grpcTest struct {
+	rt       *modulestest.Runtime
+	tb       *httpmultibin.HTTPMultiBin
+	samples  chan metrics.SampleContainer
+	received chan metadata.MD
+}
+
+func newGRPCTest(t *testing.T, options Options) *grpcTest {
+	t.Helper()
+
+	gt := &grpcTest{
+		tb:       httpmultibin.NewHTTPMultiBin(t),
+		samples:  make(chan metrics.SampleContainer, 1000),
+		received: make(chan metadata.MD, 1),
+	}
+	reflection.Register(gt.tb.ServerGRPC)
+	gt.tb.GRPCStub.EmptyCallFunc = func(ctx context.Context, _ *grpc_testing.Empty) (*grpc_testing.Empty, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		gt.received <- md
+		return &grpc_testing.Empty{}, nil
+	}
+
+	gt.rt = modulestest.NewRuntime(t)
+	grpcModule, ok := k6grpc.New().NewModuleInstance(gt.rt.VU).(*k6grpc.ModuleInstance)
+	require.True(t, ok)
+	grpcClient, ok := grpcModule.NewClient(goja.ConstructorCall{}).Export().(*k6grpc.Client)
+	require.True(t, ok)
+	require.NoError(t, gt.rt.VU.Runtime().Set("client", NewGRPC(gt.rt.VU, grpcClient, options)))
+
+	registry := metrics.NewRegistry()
+	root, err := lib.NewGroup("", nil)
+	require.NoError(t, err)
+	gt.rt.MoveToVUContext(&lib.State{
+		Group:          root,
+		Dialer:         gt.tb.Dialer,
+		TLSConfig:      gt.tb.TLSClientConfig,
+		Samples:        gt.samples,
+		Options:        lib.Options{UserAgent: null.StringFrom("k6-test")},
+		BuiltinMetrics: metrics.RegisterBuiltinMetrics(registry),
+		Tags:           lib.NewVUStateTags(registry.RootTagSet()),
+	})
+	return gt
+}
+
+// invoke makes an RPC with the given metadata object, and returns the trace
+// ID of the response.
+func (gt *grpcTest) invoke(t *testing.T, metadata string) string {
+	t.Helper()
+
+	val, err := gt.rt.VU.Runtime().RunString(gt.tb.Replacer.Replace(`
+		client.connect("GRPCBIN_ADDR", {reflect: true});
+		var res = client.invoke("grpc.testing.TestService/EmptyCall", {}, {metadata: ` + metadata + `});
+		if (res.error) {
+			throw new Error("unexpected error " + JSON.stringify(res.error));
+		}
+		client.close();
+		res.trace_id;
+	`))
+	require.NoError(t, err)
+	return val.String()
+}
+
+// spanMetadata returns the metadata of the samples of a traced RPC.
+func (gt *grpcTest) spanMetadata(traceID string) map[string]string {
+	close(gt.samples)
+	var span map[string]string
+	for container := range gt.samples {
+		for _, sample := range container.GetSamples() {
+			if sample.Metadata["trace_id"] == traceID {
+				span = sample.Metadata
+			}
+		}
+	}
+	return span
+}
+
+func TestGRPCInvokeInjectsTraceContext(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		propagator string
+		check      func(t *testing.T, md metadata.MD, traceID string)
+	}{
+		{
+			propagator: PropagatorW3C,
+			check: func(t *testing.T, md metadata.MD, traceID string) {
+				require.Len(t, md.Get(HeaderNameW3C), 1)
+				assert.Regexp(t, "^00-"+traceID+"-[0-9a-f]{16}-01$", md.Get(HeaderNameW3C)[0])
+			},
+		},
+		{
+			propagator: PropagatorGRPCBin,
+			check: func(t *testing.T, md metadata.MD, traceID string) {
+				require.Len(t, md.Get(HeaderNameGRPCBin), 1)
+				bin := []byte(md.Get(HeaderNameGRPCBin)[0])
+				require.Len(t, bin, 29)
+				assert.Equal(t, traceID, hex.EncodeToString(bin[2:18]))
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.propagator, func(t *testing.T) {
+			t.Parallel()
+
+			gt := newGRPCTest(t, Options{Propagator: tt.propagator})
+			traceID := gt.invoke(t, `{"X-Custom": "value"}`)
+			require.Len(t, traceID, 32)
+
+			md := <-gt.received
+			assert.Equal(t, []string{"value"}, md.Get("x-custom"))
+			tt.check(t, md, traceID)
+			assert.NotNil(t, gt.spanMetadata(traceID), "the gRPC samples should have the trace_id metadata")
+		})
+	}
+}
+
+func TestGRPCBinHeaderModes(t *testing.T) {
+	t.Parallel()
+
+	const upstreamTraceID = "fedcba9876543210fedcba9876543210"
+	const upstreamSpanID = "fedcba9876543210"
+	upstream, err := encodeGRPCTraceBin(upstreamTraceID, upstreamSpanID, true)
+	require.NoError(t, err)
+
+	// The script's binary context is given as an ArrayBuffer, like the one
+	// tracing.inject() returns, or as a byte array.
+	tests := []struct {
+		mode  string
+		value func(rt *goja.Runtime) interface{}
+		check func(t *testing.T, sent []byte, span map[string]string)
+	}{
+		{
+			mode:  HeaderModePreserve,
+			value: func(rt *goja.Runtime) interface{} { return rt.NewArrayBuffer([]byte(upstream)) },
+			check: func(t *testing.T, sent []byte, span map[string]string) {
+				assert.Equal(t, []byte(upstream), sent)
+				assert.NotEqual(t, upstreamSpanID, span["span_id"])
+			},
+		},
+		{
+			mode:  HeaderModeChild,
+			value: func(rt *goja.Runtime) interface{} { return []byte(upstream) },
+			check: func(t *testing.T, sent []byte, span map[string]string) {
+				require.Len(t, sent, 29)
+				assert.Equal(t, upstreamTraceID, hex.EncodeToString(sent[2:18]))
+				assert.Equal(t, span["span_id"], hex.EncodeToString(sent[19:27]))
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.mode, func(t *testing.T) {
+			t.Parallel()
+
+			gt := newGRPCTest(t, Options{Propagator: PropagatorGRPCBin, HeaderMode: tt.mode})
+			rt := gt.rt.VU.Runtime()
+			require.NoError(t, rt.Set("upstream", tt.value(rt)))
+			traceID := gt.invoke(t, `{"Grpc-Trace-Bin": upstream}`)
+			assert.Equal(t, upstreamTraceID, traceID)
+
+			md := <-gt.received
+			require.Len(t, md.Get(HeaderNameGRPCBin), 1)
+			span := gt.spanMetadata(traceID)
+			require.NotNil(t, span)
+			assert.Equal(t, upstreamSpanID, span["parent_span_id"])
+			tt.check(t, []byte(md.Get(HeaderNameGRPCBin)[0]), span)
+		})
+	}
+}
+
+func TestEncodeGRPCTraceBin(t *testing.T) {
+	t.Parallel()
+
+	bin, err := encodeGRPCTraceBin("0123456789abcdef0123456789abcdef", "0123456789abcdef", true)
+	require.NoError(t, err)
+	assert.Equal(t, "00"+"00"+"0123456789abcdef0123456789abcdef"+"01"+"0123456789abcdef"+"0201", hex.EncodeToString([]byte(bin)))
+
+	_, err = encodeGRPCTraceBin("0123", "0123456789abcdef", true)
+	assert.Error(t, err)
+}
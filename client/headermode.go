@@ -0,0 +1,71 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// The header modes tell what to do when the script already set the header of
+// the propagator on a traced request.
+const (
+	// HeaderModeOverride replaces the script's header with the one of a new
+	// trace. It's the default.
+	HeaderModeOverride = "override"
+	// HeaderModePreserve sends the script's header as it is, e.g. one of
+	// replayed production traffic. The span of the request is still a child of
+	// the span in the header, with its own span ID, so a header that's sent
+	// more than once doesn't make several spans with the same ID.
+	HeaderModePreserve = "preserve"
+	// HeaderModeChild makes the span of the request a child of the span in
+	// the script's header, e.g. an upstream traceparent.
+	HeaderModeChild = "child"
+)
+
+// ValidateHeaderMode returns an error if the header mode isn't supported.
+func ValidateHeaderMode(mode string) error {
+	switch mode {
+	case "", HeaderModeOverride, HeaderModePreserve, HeaderModeChild:
+		return nil
+	default:
+		return fmt.Errorf("unknown header mode '%s', it should be one of %s, %s or %s",
+			mode, HeaderModeOverride, HeaderModePreserve, HeaderModeChild)
+	}
+}
+
+// newRequestSpan creates the span of a traced request, given the value of the
+// propagator's header the script set, if it did. It returns the headers to
+// send, which are nil if the script's header should be sent as it is.
+func newRequestSpan(options Options, userHeader string, hasUserHeader bool) (SpanContext, http.Header, error) {
+	if !hasUserHeader || options.HeaderMode == "" || options.HeaderMode == HeaderModeOverride {
+		ctx, err := NewSpanContext(options.Propagator, nil)
+		if err != nil {
+			return ctx, nil, err
+		}
+		headers, err := GenerateHeaderForSpanContext(ctx)
+		return ctx, headers, err
+	}
+
+	userCtx, err := ParseHeaderBasedOnPropagator(options.Propagator, userHeader)
+	if err != nil {
+		return SpanContext{}, nil, fmt.Errorf("can't use the %s trace header of the request in the %s header mode: %w",
+			options.Propagator, options.HeaderMode, err)
+	}
+	ctx, err := NewSpanContext(options.Propagator, &userCtx)
+	if err != nil || options.HeaderMode == HeaderModePreserve {
+		return ctx, nil, err
+	}
+	headers, err := GenerateHeaderForSpanContext(ctx)
+	return ctx, headers, err
+}
+
+// spanMetadata returns the metadata of the samples of a request's span.
+func spanMetadata(ctx SpanContext) map[string]string {
+	metadata := map[string]string{
+		"trace_id": ctx.TraceID,
+		"span_id":  ctx.SpanID,
+	}
+	if ctx.ParentSpanID != "" {
+		metadata["parent_span_id"] = ctx.ParentSpanID
+	}
+	return metadata
+}
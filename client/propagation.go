@@ -0,0 +1,293 @@
+package client
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dop251/goja"
+)
+
+// SpanContext is the trace context carried by a propagation header.
+type SpanContext struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Sampled      bool
+	Propagator   string
+}
+
+// IsValid returns whether the trace and span IDs are lowercase hex of the
+// right length, 16 and 8 bytes, and not all zeros.
+func (ctx SpanContext) IsValid() bool {
+	return isHex(ctx.TraceID, 32) && isHex(ctx.SpanID, 16) && !isZeroHex(ctx.TraceID) && !isZeroHex(ctx.SpanID)
+}
+
+// propagatorHeaders are the headers looked up when extracting a trace context,
+// in order of precedence.
+var propagatorHeaders = []struct {
+	propagator string
+	header     string
+}{
+	{PropagatorW3C, HeaderNameW3C},
+	{PropagatorB3, HeaderNameB3},
+	{PropagatorJaeger, HeaderNameJaeger},
+	{PropagatorGRPCBin, HeaderNameGRPCBin},
+}
+
+// HeaderNameForPropagator returns the name of the header a propagator uses.
+func HeaderNameForPropagator(propagator string) (string, error) {
+	for _, p := range propagatorHeaders {
+		if p.propagator == propagator {
+			return p.header, nil
+		}
+	}
+	return "", fmt.Errorf("unknown propagator: %s", propagator)
+}
+
+// NewSpanContext creates the context of a new span, which is a child of the
+// given parent, or the root span of a new trace if there is no parent.
+func NewSpanContext(propagator string, parent *SpanContext) (SpanContext, error) {
+	if parent != nil {
+		return SpanContext{
+			TraceID:      parent.TraceID,
+			SpanID:       RandHexStringRunes(SpanIDSize),
+			ParentSpanID: parent.SpanID,
+			Sampled:      parent.Sampled,
+			Propagator:   propagator,
+		}, nil
+	}
+	traceID, spanID, err := newTraceAndSpanID()
+	if err != nil {
+		return SpanContext{}, err
+	}
+	return SpanContext{TraceID: traceID, SpanID: spanID, Sampled: true, Propagator: propagator}, nil
+}
+
+// ExtractTraceContext looks up the header of the given propagator, or of any
+// supported one if the propagator is empty, in a carrier of key/value pairs,
+// e.g. the headers of a message. The keys are matched case-insensitively.
+// It returns false if there's no trace header, and an error if there's one
+// that can't be parsed.
+func ExtractTraceContext(carrier map[string]string, propagator string) (SpanContext, bool, error) {
+	lowercased := make(map[string]string, len(carrier))
+	for key, val := range carrier {
+		lowercased[strings.ToLower(key)] = val
+	}
+
+	for _, p := range propagatorHeaders {
+		if propagator != "" && p.propagator != propagator {
+			continue
+		}
+		value, ok := lowercased[p.header]
+		if !ok {
+			continue
+		}
+		ctx, err := ParseHeaderBasedOnPropagator(p.propagator, value)
+		if err != nil {
+			return SpanContext{}, true, err
+		}
+		return ctx, true, nil
+	}
+	if propagator != "" {
+		if _, err := HeaderNameForPropagator(propagator); err != nil {
+			return SpanContext{}, false, err
+		}
+	}
+	return SpanContext{}, false, nil
+}
+
+// CarrierValue converts the value of a carrier entry, like a message header
+// or gRPC metadata, to a string. Values are often byte arrays, e.g. the one of
+// grpc-trace-bin, or lists of values, in which case the first one is used.
+func CarrierValue(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	case goja.ArrayBuffer:
+		return string(v.Bytes()), true
+	case []interface{}:
+		if len(v) > 0 {
+			return CarrierValue(v[0])
+		}
+	}
+	return "", false
+}
+
+// ParseHeaderBasedOnPropagator parses the value of a propagator's header. It
+// is the counterpart of GenerateHeaderBasedOnPropagator.
+func ParseHeaderBasedOnPropagator(propagator string, value string) (SpanContext, error) {
+	ctx := SpanContext{Propagator: propagator}
+	invalid := fmt.Errorf("invalid %s trace context: %q", propagator, value)
+
+	switch propagator {
+	case PropagatorW3C:
+		// Docs: https://www.w3.org/TR/trace-context/#traceparent-header-field-values
+		parts := strings.Split(strings.TrimSpace(value), "-")
+		if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+			return ctx, invalid
+		}
+		if !isHex(parts[3], 2) {
+			return ctx, invalid
+		}
+		flags, _ := strconv.ParseUint(parts[3], 16, 8)
+		ctx.TraceID, ctx.SpanID, ctx.Sampled = parts[1], parts[2], flags&1 == 1
+	case PropagatorB3:
+		// Docs: https://github.com/openzipkin/b3-propagation#single-header
+		parts := strings.Split(strings.TrimSpace(value), "-")
+		if len(parts) < 2 || len(parts) > 4 {
+			return ctx, invalid
+		}
+		ctx.TraceID, ctx.SpanID, ctx.Sampled = padHex(parts[0], 32), parts[1], true
+		if len(parts) > 2 {
+			if parts[2] != "0" && parts[2] != "1" && parts[2] != "d" {
+				return ctx, invalid
+			}
+			ctx.Sampled = parts[2] != "0"
+		}
+		if len(parts) > 3 {
+			if !isHex(parts[3], 16) {
+				return ctx, invalid
+			}
+			ctx.ParentSpanID = parts[3]
+		}
+	case PropagatorJaeger:
+		// Docs: https://www.jaegertracing.io/docs/1.29/client-libraries/#value-format
+		parts := strings.Split(strings.TrimSpace(value), ":")
+		if len(parts) != 4 {
+			return ctx, invalid
+		}
+		flags, err := strconv.ParseUint(parts[3], 16, 8)
+		if err != nil {
+			return ctx, invalid
+		}
+		ctx.TraceID, ctx.SpanID, ctx.Sampled = padHex(parts[0], 32), padHex(parts[1], 16), flags&1 == 1
+		if parts[2] != "0" {
+			ctx.ParentSpanID = padHex(parts[2], 16)
+		}
+	case PropagatorGRPCBin:
+		traceID, spanID, sampled, err := decodeGRPCTraceBin(value)
+		if err != nil {
+			return ctx, invalid
+		}
+		ctx.TraceID, ctx.SpanID, ctx.Sampled = traceID, spanID, sampled
+	default:
+		return ctx, fmt.Errorf("unknown propagator: %s", propagator)
+	}
+
+	if !ctx.IsValid() {
+		return SpanContext{Propagator: propagator}, invalid
+	}
+	return ctx, nil
+}
+
+// decodeGRPCTraceBin decodes the binary format of the grpc-trace-bin metadata.
+// Unknown trailing fields are ignored, as the format requires.
+func decodeGRPCTraceBin(value string) (string, string, bool, error) {
+	buf := []byte(value)
+	if len(buf) < 1 || buf[0] != 0 {
+		return "", "", false, fmt.Errorf("unsupported grpc-trace-bin version")
+	}
+	var traceID, spanID string
+	var sampled bool
+	for i := 1; i < len(buf); {
+		switch field := buf[i]; {
+		case field == 0 && i+17 <= len(buf):
+			traceID = hex.EncodeToString(buf[i+1 : i+17])
+			i += 17
+		case field == 1 && i+9 <= len(buf):
+			spanID = hex.EncodeToString(buf[i+1 : i+9])
+			i += 9
+		case field == 2 && i+2 <= len(buf):
+			sampled = buf[i+1]&1 == 1
+			i += 2
+		default:
+			i = len(buf)
+		}
+	}
+	if traceID == "" || spanID == "" {
+		return "", "", false, fmt.Errorf("missing grpc-trace-bin IDs")
+	}
+	return traceID, spanID, sampled, nil
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+func isZeroHex(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
+// padHex left-pads the shorter IDs some propagators allow, e.g. the 64-bit
+// trace IDs of B3 and Jaeger.
+func padHex(s string, length int) string {
+	if len(s) >= length {
+		return s
+	}
+	return strings.Repeat("0", length-len(s)) + s
+}
+
+const (
+	// HeaderNameTraceResponse is the response header of the W3C Trace Context
+	// Level 2 draft, with which a server tells the trace it recorded.
+	HeaderNameTraceResponse = "traceresponse"
+	HeaderNameServerTiming  = "server-timing"
+)
+
+// ParseServerTraceContext looks for the trace context a server returned in
+// the headers of a response, either in a traceresponse header or in a
+// traceparent entry of the Server-Timing header. It returns false if there's
+// none, or if it can't be parsed.
+//
+// Docs: https://w3c.github.io/trace-context/#traceresponse-header
+func ParseServerTraceContext(headers map[string]string) (SpanContext, bool) {
+	var traceResponse, serverTiming string
+	for key, val := range headers {
+		switch strings.ToLower(key) {
+		case HeaderNameTraceResponse:
+			traceResponse = val
+		case HeaderNameServerTiming:
+			serverTiming = val
+		}
+	}
+
+	if traceResponse != "" {
+		if ctx, err := ParseHeaderBasedOnPropagator(PropagatorW3C, traceResponse); err == nil {
+			return ctx, true
+		}
+	}
+	if value, ok := serverTimingTraceparent(serverTiming); ok {
+		if ctx, err := ParseHeaderBasedOnPropagator(PropagatorW3C, value); err == nil {
+			return ctx, true
+		}
+	}
+	return SpanContext{}, false
+}
+
+// serverTimingTraceparent returns the description of the traceparent metric
+// of a Server-Timing header, e.g. `traceparent;desc="00-...-01"`.
+//
+// Docs: https://www.w3.org/TR/server-timing/#the-server-timing-header-field
+func serverTimingTraceparent(header string) (string, bool) {
+	for _, metric := range strings.Split(header, ",") {
+		params := strings.Split(metric, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), HeaderNameW3C) {
+			continue
+		}
+		for _, param := range params[1:] {
+			nameValue := strings.SplitN(param, "=", 2)
+			if len(nameValue) == 2 && strings.EqualFold(strings.TrimSpace(nameValue[0]), "desc") {
+				return strings.Trim(strings.TrimSpace(nameValue[1]), `"`), true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTraceID = "0123456789abcdef0123456789abcdef"
+	testSpanID  = "0123456789abcdef"
+)
+
+func TestParseHeaderRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, propagator := range []string{PropagatorW3C, PropagatorB3, PropagatorJaeger, PropagatorGRPCBin} {
+		headers, err := GenerateHeaderBasedOnPropagator(propagator, testTraceID, testSpanID)
+		require.NoError(t, err)
+		name, err := HeaderNameForPropagator(propagator)
+		require.NoError(t, err)
+
+		ctx, err := ParseHeaderBasedOnPropagator(propagator, headers[name][0])
+		require.NoError(t, err, propagator)
+		assert.Equal(t, SpanContext{
+			TraceID:    testTraceID,
+			SpanID:     testSpanID,
+			Sampled:    true,
+			Propagator: propagator,
+		}, ctx, propagator)
+	}
+}
+
+func TestParseHeaderBasedOnPropagator(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		propagator string
+		value      string
+		want       SpanContext
+		wantErr    bool
+	}{
+		{
+			propagator: PropagatorW3C,
+			value:      "00-" + testTraceID + "-" + testSpanID + "-00",
+			want:       SpanContext{TraceID: testTraceID, SpanID: testSpanID},
+		},
+		{propagator: PropagatorW3C, value: "00-" + testTraceID + "-" + testSpanID, wantErr: true},
+		{propagator: PropagatorW3C, value: "ff-" + testTraceID + "-" + testSpanID + "-01", wantErr: true},
+		{propagator: PropagatorW3C, value: "00-00000000000000000000000000000000-" + testSpanID + "-01", wantErr: true},
+		{propagator: PropagatorW3C, value: "00-" + testTraceID + "-" + testSpanID + "-01-extra", wantErr: true},
+		{
+			propagator: PropagatorB3,
+			value:      "0123456789abcdef-" + testSpanID + "-d-fedcba9876543210",
+			want:       SpanContext{TraceID: "00000000000000000123456789abcdef", SpanID: testSpanID, ParentSpanID: "fedcba9876543210", Sampled: true},
+		},
+		{
+			propagator: PropagatorB3,
+			value:      testTraceID + "-" + testSpanID,
+			want:       SpanContext{TraceID: testTraceID, SpanID: testSpanID, Sampled: true},
+		},
+		{propagator: PropagatorB3, value: "1", wantErr: true},
+		{
+			propagator: PropagatorJaeger,
+			value:      "abcdef:123:fedcba9876543210:0",
+			want:       SpanContext{TraceID: "00000000000000000000000000abcdef", SpanID: "0000000000000123", ParentSpanID: "fedcba9876543210"},
+		},
+		{propagator: PropagatorJaeger, value: testTraceID + ":" + testSpanID + ":0", wantErr: true},
+		{propagator: PropagatorGRPCBin, value: "\x00\x00", wantErr: true},
+		{propagator: "unknown", value: "value", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHeaderBasedOnPropagator(tt.propagator, tt.value)
+		if tt.wantErr {
+			assert.Error(t, err, tt.value)
+			continue
+		}
+		require.NoError(t, err, tt.value)
+		tt.want.Propagator = tt.propagator
+		assert.Equal(t, tt.want, got, tt.value)
+	}
+}
+
+func TestExtractTraceContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, found, err := ExtractTraceContext(map[string]string{
+		"Content-Type":  "application/json",
+		"Uber-Trace-Id": testTraceID + ":" + testSpanID + ":0:1",
+	}, "")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, PropagatorJaeger, ctx.Propagator)
+	assert.Equal(t, testTraceID, ctx.TraceID)
+
+	_, found, err = ExtractTraceContext(map[string]string{
+		"uber-trace-id": testTraceID + ":" + testSpanID + ":0:1",
+	}, PropagatorW3C)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, found, err = ExtractTraceContext(map[string]string{"traceparent": "garbage"}, "")
+	assert.True(t, found)
+	assert.Error(t, err)
+
+	_, _, err = ExtractTraceContext(map[string]string{}, "unknown")
+	assert.Error(t, err)
+}
+
+func TestNewSpanContext(t *testing.T) {
+	t.Parallel()
+
+	root, err := NewSpanContext(PropagatorW3C, nil)
+	require.NoError(t, err)
+	assert.Len(t, root.TraceID, 32)
+	assert.Len(t, root.SpanID, SpanIDSize)
+	assert.Empty(t, root.ParentSpanID)
+	assert.True(t, root.Sampled)
+
+	child, err := NewSpanContext(PropagatorB3, &root)
+	require.NoError(t, err)
+	assert.Equal(t, root.TraceID, child.TraceID)
+	assert.Equal(t, root.SpanID, child.ParentSpanID)
+	assert.NotEqual(t, root.SpanID, child.SpanID)
+	assert.Equal(t, PropagatorB3, child.Propagator)
+}
+
+func TestParseServerTraceContext(t *testing.T) {
+	t.Parallel()
+
+	traceparent := "00-" + testTraceID + "-" + testSpanID + "-01"
+	tests := []struct {
+		headers map[string]string
+		found   bool
+	}{
+		{map[string]string{"Traceresponse": traceparent}, true},
+		{map[string]string{"Server-Timing": "traceparent;desc=" + traceparent}, true},
+		{map[string]string{"Server-Timing": `db;dur=53, TraceParent; desc="` + traceparent + `"`}, true},
+		{map[string]string{"Traceresponse": "garbage", "Server-Timing": `traceparent;desc="` + traceparent + `"`}, true},
+		{map[string]string{"Server-Timing": "db;dur=53"}, false},
+		{map[string]string{"Traceparent": traceparent}, false},
+	}
+	for _, tt := range tests {
+		ctx, found := ParseServerTraceContext(tt.headers)
+		assert.Equal(t, tt.found, found, tt.headers)
+		if tt.found {
+			assert.Equal(t, testTraceID, ctx.TraceID, tt.headers)
+			assert.Equal(t, testSpanID, ctx.SpanID, tt.headers)
+		}
+	}
+}
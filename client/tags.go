@@ -0,0 +1,119 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+)
+
+// The fields of a span context that can be promoted from the metadata to the
+// tags of the samples of a traced request.
+const (
+	PromotedTagTraceID    = "trace_id"
+	PromotedTagSampled    = "sampled"
+	PromotedTagPropagator = "propagator"
+)
+
+// ValidatePromotedTags returns an error if one of the tags can't be promoted.
+func ValidatePromotedTags(tags []string) error {
+	for _, tag := range tags {
+		switch tag {
+		case PromotedTagTraceID, PromotedTagSampled, PromotedTagPropagator:
+		default:
+			return fmt.Errorf("the '%s' tag can't be promoted, it should be one of %s, %s or %s",
+				tag, PromotedTagTraceID, PromotedTagSampled, PromotedTagPropagator)
+		}
+	}
+	return nil
+}
+
+// promotedTags returns the values of the promoted tags for a span.
+func promotedTags(names []string, span SpanContext) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(names))
+	for _, name := range names {
+		switch name {
+		case PromotedTagTraceID:
+			tags[name] = span.TraceID
+		case PromotedTagSampled:
+			tags[name] = strconv.FormatBool(span.Sampled)
+		case PromotedTagPropagator:
+			tags[name] = span.Propagator
+		}
+	}
+	return tags
+}
+
+// setTraceTags adds the given tags to the samples the VU emits until the
+// returned function is called, which restores any tag they replaced.
+func setTraceTags(state *lib.State, tags map[string]string) func() {
+	if len(tags) == 0 {
+		return func() {}
+	}
+	previous := make(map[string]string)
+	state.Tags.Modify(func(tagsAndMeta *metrics.TagsAndMeta) {
+		for key, val := range tags {
+			if old, exists := tagsAndMeta.Tags.Get(key); exists {
+				previous[key] = old
+			}
+			tagsAndMeta.SetTag(key, val)
+		}
+	})
+	return func() {
+		state.Tags.Modify(func(tagsAndMeta *metrics.TagsAndMeta) {
+			for key := range tags {
+				if old, existed := previous[key]; existed {
+					tagsAndMeta.SetTag(key, old)
+				} else {
+					tagsAndMeta.DeleteTag(key)
+				}
+			}
+		})
+	}
+}
+
+// countTracedRequest emits a sample of the traced requests counter, with the
+// current tags of the VU, so the trace coverage can be seen in the summary and
+// used in thresholds.
+func countTracedRequest(vu modules.VU, state *lib.State, metric *metrics.Metric) {
+	if metric == nil {
+		return
+	}
+	tagsAndMeta := state.Tags.GetCurrentValues()
+	metrics.PushIfNotDone(vu.Context(), state.Samples, metrics.Sample{
+		TimeSeries: metrics.TimeSeries{
+			Metric: metric,
+			Tags:   tagsAndMeta.Tags,
+		},
+		Time:     time.Now(),
+		Metadata: tagsAndMeta.Metadata,
+		Value:    1,
+	})
+}
+
+// PushSpanSample emits a sample of an instantaneous span, like the one of a
+// WebSocket message, with the current tags of the VU. The outputs turn it
+// into a span from the trace_id, span_id and parent_span_id in its metadata.
+func PushSpanSample(vu modules.VU, state *lib.State, metric *metrics.Metric, metadata map[string]string) {
+	tagsAndMeta := state.Tags.GetCurrentValues()
+	for key, val := range metadata {
+		tagsAndMeta.SetMetadata(key, val)
+	}
+	tagsAndMeta.SetMetadata("vu", strconv.FormatUint(state.VUID, 10))
+	tagsAndMeta.SetMetadata("iter", strconv.FormatInt(state.Iteration, 10))
+	metrics.PushIfNotDone(vu.Context(), state.Samples, metrics.Sample{
+		TimeSeries: metrics.TimeSeries{
+			Metric: metric,
+			Tags:   tagsAndMeta.Tags,
+		},
+		Time:     time.Now(),
+		Metadata: tagsAndMeta.Metadata,
+		Value:    1,
+	})
+}
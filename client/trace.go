@@ -0,0 +1,44 @@
+package client
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// newTraceObject creates the `trace` object of the response of a traced
+// request. It's a map rather than a struct, so its keys are the same whatever
+// field name mapper the JS runtime uses. The IDs are available in both
+// camelCase and snake_case.
+func newTraceObject(span SpanContext, headers map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"traceId":        span.TraceID,
+		"spanId":         span.SpanID,
+		"parentSpanId":   span.ParentSpanID,
+		"trace_id":       span.TraceID,
+		"span_id":        span.SpanID,
+		"parent_span_id": span.ParentSpanID,
+		"sampled":        span.Sampled,
+		"propagator":     span.Propagator,
+		"headers":        headers,
+	}
+}
+
+// sentTraceHeaders returns the trace headers a request was sent with: the
+// generated ones, or the script's one if it was preserved.
+func sentTraceHeaders(headerName string, userHeader string, tracingHeaders http.Header) map[string]string {
+	if tracingHeaders == nil {
+		return map[string]string{headerName: userHeader}
+	}
+	headers := make(map[string]string, len(tracingHeaders))
+	for key, values := range tracingHeaders {
+		value := strings.Join(values, ", ")
+		if key == HeaderNameGRPCBin {
+			// Binary metadata is base64 encoded on the wire, and would be
+			// mangled by a conversion to a JS string.
+			value = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+		headers[key] = value
+	}
+	return headers
+}
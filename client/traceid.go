@@ -17,29 +17,88 @@ const (
 	HeaderNameB3     = "b3"
 	PropagatorJaeger = "jaeger"
 	HeaderNameJaeger = "uber-trace-id"
+	// PropagatorGRPCBin is the binary OpenCensus format, which can only be
+	// used in gRPC metadata.
+	PropagatorGRPCBin = "grpc-trace-bin"
+	HeaderNameGRPCBin = "grpc-trace-bin"
 )
 
-func GenerateHeaderBasedOnPropagator(propagator string, traceID string) (http.Header, error) {
+// SpanIDSize is the length of the hex-encoded span IDs, i.e. 8 bytes.
+const SpanIDSize = 16
 
-	switch propagator {
+func GenerateHeaderBasedOnPropagator(propagator string, traceID string, spanID string) (http.Header, error) {
+	return GenerateHeaderForSpanContext(SpanContext{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		Sampled:    true,
+		Propagator: propagator,
+	})
+}
+
+// GenerateHeaderForSpanContext generates the header of a span context in the
+// format of its propagator, with its sampling decision.
+func GenerateHeaderForSpanContext(ctx SpanContext) (http.Header, error) {
+	traceID, spanID := ctx.TraceID, ctx.SpanID
+	sampled := 0
+	if ctx.Sampled {
+		sampled = 1
+	}
+
+	switch ctx.Propagator {
 	case PropagatorW3C:
 		// Docs: https://www.w3.org/TR/trace-context/#version-format
 		return http.Header{
-			HeaderNameW3C: {fmt.Sprintf("00-%s-%s-01", traceID, RandHexStringRunes(16))},
+			HeaderNameW3C: {fmt.Sprintf("00-%s-%s-%02d", traceID, spanID, sampled)},
 		}, nil
 	case PropagatorB3:
 		// Docs: https://github.com/openzipkin/b3-propagation#single-header
 		return http.Header{
-			HeaderNameB3: {fmt.Sprintf("%s-%s-1", traceID, RandHexStringRunes(8))},
+			HeaderNameB3: {fmt.Sprintf("%s-%s-%d", traceID, spanID, sampled)},
 		}, nil
 	case PropagatorJaeger:
 		// Docs: https://www.jaegertracing.io/docs/1.29/client-libraries/#tracespan-identity
 		return http.Header{
-			HeaderNameJaeger: {fmt.Sprintf("%s:%s:0:1", traceID, RandHexStringRunes(8))},
+			HeaderNameJaeger: {fmt.Sprintf("%s:%s:0:%d", traceID, spanID, sampled)},
+		}, nil
+	case PropagatorGRPCBin:
+		value, err := encodeGRPCTraceBin(traceID, spanID, ctx.Sampled)
+		if err != nil {
+			return nil, err
+		}
+		return http.Header{
+			HeaderNameGRPCBin: {value},
 		}, nil
 	default:
-		return nil, fmt.Errorf("unknown propagator: %s", propagator)
+		return nil, fmt.Errorf("unknown propagator: %s", ctx.Propagator)
+	}
+}
+
+// encodeGRPCTraceBin encodes a span context in the binary format of the
+// grpc-trace-bin metadata.
+//
+// Docs: https://github.com/census-instrumentation/opencensus-specs/blob/master/encodings/BinaryEncoding.md
+func encodeGRPCTraceBin(traceID string, spanID string, sampled bool) (string, error) {
+	traceIDBytes, err := hex.DecodeString(traceID)
+	if err != nil || len(traceIDBytes) != 16 {
+		return "", fmt.Errorf("invalid trace ID: %s", traceID)
+	}
+	spanIDBytes, err := hex.DecodeString(spanID)
+	if err != nil || len(spanIDBytes) != 8 {
+		return "", fmt.Errorf("invalid span ID: %s", spanID)
+	}
+
+	buf := make([]byte, 0, 29)
+	buf = append(buf, 0) // version
+	buf = append(buf, 0)
+	buf = append(buf, traceIDBytes...)
+	buf = append(buf, 1)
+	buf = append(buf, spanIDBytes...)
+	if sampled {
+		buf = append(buf, 2, 1)
+	} else {
+		buf = append(buf, 2, 0)
 	}
+	return string(buf), nil
 }
 
 var hexRunes = []rune("123456789abcdef")
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/netext/httpext"
+	"go.k6.io/k6/metrics"
+)
+
+// trailHolder holds back the trails of traced requests until they're done, so
+// they can be annotated with what's only known from the response, like the
+// server's trace context, before the outputs see them, and so the expected
+// response result k6 put on them can be read.
+//
+// k6 pushes the trails of a request, one per redirect hop, to the VU's samples
+// channel from within request(), so lib.State.Samples is replaced by the
+// holder's channel for the duration of the call. The field isn't synchronized:
+// this relies on the JS code of a VU, and the synchronous requests it makes,
+// running on a single goroutine, which is the only one that reads the field
+// during the call. Something that captured the channel during the call, and
+// pushes to it later, doesn't panic or lose its samples though: the channel
+// is never closed, and the holder's goroutine forwards everything but the
+// held trails to the VU's channel until the VU's context is done.
+type trailHolder struct {
+	ctx  context.Context
+	out  chan<- metrics.SampleContainer
+	in   chan metrics.SampleContainer
+	done chan struct{}
+}
+
+// holdMark and releaseMark tell the holder's goroutine where the samples of a
+// call start and end, in the order they were pushed.
+type (
+	holdMark    struct{}
+	releaseMark struct {
+		trails chan []*httpext.Trail
+	}
+)
+
+func (holdMark) GetSamples() []metrics.Sample    { return nil }
+func (releaseMark) GetSamples() []metrics.Sample { return nil }
+
+func newTrailHolder(ctx context.Context, out chan<- metrics.SampleContainer) *trailHolder {
+	h := &trailHolder{
+		ctx:  ctx,
+		out:  out,
+		in:   make(chan metrics.SampleContainer, cap(out)),
+		done: make(chan struct{}),
+	}
+	go h.forward()
+	return h
+}
+
+func (h *trailHolder) forward() {
+	defer close(h.done)
+	var held []*httpext.Trail
+	holding := false
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case container := <-h.in:
+			switch c := container.(type) {
+			case holdMark:
+				holding = true
+			case releaseMark:
+				c.trails <- held
+				held, holding = nil, false
+			case *httpext.Trail:
+				if holding {
+					held = append(held, c)
+					continue
+				}
+				metrics.PushIfNotDone(h.ctx, h.out, c)
+			default:
+				metrics.PushIfNotDone(h.ctx, h.out, container)
+			}
+		}
+	}
+}
+
+// usable returns whether the holder can hold the trails of a call made with
+// the given context and samples channel.
+func (h *trailHolder) usable(ctx context.Context, out chan<- metrics.SampleContainer) bool {
+	select {
+	case <-h.done:
+		return false
+	default:
+		return h.ctx == ctx && h.out == out
+	}
+}
+
+// send sends a mark to the holder's goroutine, unless it's already gone.
+func (h *trailHolder) send(mark metrics.SampleContainer) bool {
+	select {
+	case <-h.done:
+		return false
+	default:
+	}
+	select {
+	case h.in <- mark:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// hold starts holding the trails the VU pushes, until the returned trails
+// are released.
+func (h *trailHolder) hold(state *lib.State) *heldTrails {
+	t := &heldTrails{state: state, Metadata: make(map[string]string)}
+	if h.send(holdMark{}) {
+		t.holder = h
+		state.Samples = h.in
+	}
+	return t
+}
+
+// heldTrails are the trails of a single traced call.
+type heldTrails struct {
+	holder   *trailHolder
+	state    *lib.State
+	trails   []*httpext.Trail
+	released bool
+
+	// Metadata is added to the metadata of the last trail, the one of the
+	// final response, when the trails are released.
+	Metadata map[string]string
+}
+
+// release restores the VU's samples channel and pushes the held trails. Only
+// the first call does anything, so it can also be deferred.
+func (t *heldTrails) release() {
+	if t.released || t.holder == nil {
+		t.released = true
+		return
+	}
+	t.released = true
+	h := t.holder
+	t.state.Samples = h.out
+
+	mark := releaseMark{trails: make(chan []*httpext.Trail, 1)}
+	if h.send(mark) {
+		select {
+		case t.trails = <-mark.trails:
+		case <-h.done:
+		}
+	}
+
+	if len(t.trails) > 0 && len(t.Metadata) > 0 {
+		// The hops of a redirect chain may share their metadata map.
+		last := t.trails[len(t.trails)-1]
+		metadata := make(map[string]string, len(last.Metadata)+len(t.Metadata))
+		for key, val := range last.Metadata {
+			metadata[key] = val
+		}
+		for key, val := range t.Metadata {
+			metadata[key] = val
+		}
+		last.Metadata = metadata
+	}
+	for _, trail := range t.trails {
+		metrics.PushIfNotDone(h.ctx, h.out, trail)
+	}
+}
+
+// unexpected returns whether k6 didn't expect the final response, according
+// to the response callback of the request, i.e. whether it counted it in
+// http_req_failed. It's only known once the trails are released, and false if
+// the request had no response callback.
+func (t *heldTrails) unexpected() bool {
+	if len(t.trails) == 0 {
+		return false
+	}
+	failed := t.trails[len(t.trails)-1].Failed
+	return failed.Valid && failed.Bool
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/guregu/null.v3"
+
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/netext/httpext"
+	"go.k6.io/k6/metrics"
+)
+
+func TestHeldTrailsChannelOutlivesTheCall(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan metrics.SampleContainer, 10)
+	state := &lib.State{Samples: out}
+
+	holder := newTrailHolder(ctx, out)
+	held := holder.hold(state)
+	captured := state.Samples
+	other := metrics.Samples{}
+	captured <- &httpext.Trail{Failed: null.BoolFrom(true)}
+	captured <- other
+	held.Metadata["response_bytes"] = "12"
+	held.release()
+	held.release()
+
+	assert.Equal(t, (chan<- metrics.SampleContainer)(out), state.Samples)
+	assert.True(t, held.unexpected())
+	require.Len(t, out, 2)
+	assert.Equal(t, other, <-out, "only the trails are held")
+	trail, ok := (<-out).(*httpext.Trail)
+	require.True(t, ok)
+	assert.Equal(t, "12", trail.Metadata["response_bytes"])
+
+	// Something that kept the channel of the call pushes to it afterwards.
+	late := &httpext.Trail{}
+	assert.NotPanics(t, func() { captured <- late })
+	select {
+	case got := <-out:
+		assert.Equal(t, late, got)
+	case <-time.After(time.Second):
+		t.Fatal("the late trail wasn't forwarded")
+	}
+
+	// The holder is reused for the calls of the same VU.
+	assert.True(t, holder.usable(ctx, out))
+	cancel()
+	<-holder.done
+	assert.False(t, holder.usable(ctx, out))
+	released := holder.hold(state)
+	assert.Equal(t, (chan<- metrics.SampleContainer)(out), state.Samples)
+	released.release()
+}
@@ -0,0 +1,327 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dop251/goja"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/js/modules"
+	k6ws "go.k6.io/k6/js/modules/k6/ws"
+	"go.k6.io/k6/metrics"
+)
+
+// WSOptions are the options of the traced WebSocket client, on top of the
+// common tracing options.
+type WSOptions struct {
+	Options
+
+	// MessageSpans gives each sent text message its own child span of the
+	// connection span. The message is wrapped in a JSON envelope that carries
+	// the trace context in TraceField, unless it's already a JSON object, in
+	// which case the field is added to it. TraceField defaults to the header
+	// name of the propagator, e.g. 'b3' for B3, so the receiver can extract
+	// it like a header.
+	MessageSpans bool
+	TraceField   string
+	DataField    string
+
+	// MessageMetric is the metric of the samples the message spans are
+	// recorded with.
+	MessageMetric *metrics.Metric
+}
+
+// TracingWSClient wraps the k6/ws connect() function, adding the trace context
+// to the headers of the WebSocket handshake.
+type TracingWSClient struct {
+	vu modules.VU
+	ws *k6ws.WS
+
+	options WSOptions
+}
+
+type WSResponse struct {
+	*k6ws.HTTPResponse `js:"-"`
+	TraceID            string                 `js:"trace_id"`
+	Trace              map[string]interface{} `js:"trace"`
+}
+
+// TracedSocket is the socket passed to the connect() callback. The messages
+// sent through it can have their own spans.
+type TracedSocket struct {
+	*k6ws.Socket `js:"-"`
+
+	client  *TracingWSClient
+	url     string
+	traceID string
+	spanID  string
+	sampled bool
+}
+
+func NewWS(vu modules.VU, ws *k6ws.WS, options WSOptions) *TracingWSClient {
+	return &TracingWSClient{
+		vu:      vu,
+		ws:      ws,
+		options: options,
+	}
+}
+
+// Connect opens a WebSocket connection, like the k6/ws connect() function,
+// with the trace context in the handshake headers. The whole connection is a
+// single span.
+func (c *TracingWSClient) Connect(url string, args ...goja.Value) (*WSResponse, error) {
+	state := c.vu.State()
+	if state == nil {
+		return nil, fmt.Errorf("WebSocket connections can only be made in the VU context")
+	}
+
+	var params, callback goja.Value
+	switch len(args) {
+	case 2:
+		params, callback = args[0], args[1]
+	case 1:
+		callback = args[0]
+	default:
+		return nil, errors.New("invalid number of arguments to ws.connect")
+	}
+	setupFn, isFunc := goja.AssertFunction(callback)
+	if !isFunc {
+		return nil, errors.New("last argument to ws.connect must be a function")
+	}
+
+	rt := c.vu.Runtime()
+	tracedParams := rt.NewObject()
+	headers := rt.NewObject()
+	if !IsNilly(params) {
+		paramsObj := params.ToObject(rt)
+		for _, key := range paramsObj.Keys() {
+			if key != "headers" {
+				if err := tracedParams.Set(key, paramsObj.Get(key)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if userHeaders := paramsObj.Get(key); !IsNilly(userHeaders) {
+				userHeadersObj := userHeaders.ToObject(rt)
+				for _, hk := range userHeadersObj.Keys() {
+					if err := headers.Set(hk, userHeadersObj.Get(hk)); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	headerName, err := HeaderNameForPropagator(c.options.Propagator)
+	if err != nil {
+		return nil, err
+	}
+	userHeader, hasUserHeader := findHeader(headers, headerName)
+	span, tracingHeaders, err := newRequestSpan(c.options.Options, userHeader, hasUserHeader)
+	if err != nil {
+		return nil, err
+	}
+	traceID, spanID := span.TraceID, span.SpanID
+	if err := mergeHeaders(headers, tracingHeaders); err != nil {
+		return nil, err
+	}
+	if err := tracedParams.Set("headers", headers); err != nil {
+		return nil, err
+	}
+
+	// k6 takes the metadata of all the connection samples before the
+	// handshake, so it's removed again before the callback runs, to keep it
+	// out of the samples of the requests made in the callback.
+	cleanupMetadata := setTraceMetadata(state, spanMetadata(span))
+	cleanupTags := setTraceTags(state, promotedTags(c.options.PromotedTags, span))
+	cleaned := false
+	cleanupOnce := func() {
+		if !cleaned {
+			cleaned = true
+			cleanupTags()
+			cleanupMetadata()
+		}
+	}
+	// The connection is counted before it's made, since the tags are removed
+	// once it's open.
+	countTracedRequest(c.vu, state, c.options.TracedRequestsMetric)
+	defer cleanupOnce()
+
+	tracedCallback := rt.ToValue(func(call goja.FunctionCall) goja.Value {
+		cleanupOnce()
+		socket, ok := call.Argument(0).Export().(*k6ws.Socket)
+		if !ok {
+			common.Throw(rt, fmt.Errorf("unexpected k6/ws socket type"))
+		}
+		res, err := setupFn(goja.Undefined(), rt.ToValue(&TracedSocket{
+			Socket:  socket,
+			client:  c,
+			url:     url,
+			traceID: traceID,
+			spanID:  spanID,
+			sampled: span.Sampled,
+		}))
+		if err != nil {
+			common.Throw(rt, err)
+		}
+		return res
+	})
+
+	res, err := c.ws.Connect(url, tracedParams, tracedCallback)
+	if c.options.AfterRequest != nil {
+		c.options.AfterRequest()
+	}
+
+	return &WSResponse{
+		HTTPResponse: res,
+		TraceID:      traceID,
+		Trace:        newTraceObject(span, sentTraceHeaders(headerName, userHeader, tracingHeaders)),
+	}, err
+}
+
+// Send sends a text message. With message spans enabled, the message gets the
+// trace context of its own span in its JSON envelope.
+func (s *TracedSocket) Send(message string) {
+	opts := s.client.options
+	state := s.client.vu.State()
+	if !opts.MessageSpans || state == nil {
+		s.Socket.Send(message)
+		return
+	}
+
+	headerName, err := HeaderNameForPropagator(opts.Propagator)
+	if err != nil {
+		common.Throw(s.client.vu.Runtime(), err)
+	}
+	spanID := RandHexStringRunes(SpanIDSize)
+	tracingHeaders, err := GenerateHeaderForSpanContext(SpanContext{
+		TraceID:    s.traceID,
+		SpanID:     spanID,
+		Sampled:    s.sampled,
+		Propagator: opts.Propagator,
+	})
+	if err != nil {
+		common.Throw(s.client.vu.Runtime(), err)
+	}
+	traceContext := tracingHeaders[headerName]
+	if len(traceContext) == 0 {
+		common.Throw(s.client.vu.Runtime(), fmt.Errorf("the %s propagator generated no %s header", opts.Propagator, headerName))
+	}
+	traceField := opts.TraceField
+	if traceField == "" {
+		traceField = headerName
+	}
+	message, err = wrapWSMessage(message, traceField, opts.DataField, traceContext[0])
+	if err != nil {
+		common.Throw(s.client.vu.Runtime(), err)
+	}
+
+	if opts.MessageMetric != nil {
+		PushSpanSample(s.client.vu, state, opts.MessageMetric, map[string]string{
+			"trace_id":       s.traceID,
+			"span_id":        spanID,
+			"parent_span_id": s.spanID,
+			"url":            s.url,
+		})
+	}
+
+	s.Socket.Send(message)
+}
+
+// wrapWSMessage adds the trace context to a JSON object message, or wraps
+// any other message in a JSON envelope. The fields of an object message are
+// kept as they are, in their order, so the app gets the payload the script
+// sent, with only the trace field added or replaced.
+func wrapWSMessage(message, traceField, dataField, traceContext string) (string, error) {
+	fields, ok := jsonObjectFields(message)
+	if !ok {
+		data, err := marshalJSONString(message)
+		if err != nil {
+			return "", err
+		}
+		fields = []jsonField{{key: dataField, value: data}}
+	}
+	value, err := marshalJSONString(traceContext)
+	if err != nil {
+		return "", err
+	}
+	replaced := false
+	for i := range fields {
+		if fields[i].key == traceField {
+			fields[i].value, replaced = value, true
+		}
+	}
+	if !replaced {
+		fields = append(fields, jsonField{key: traceField, value: value})
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		key, err := marshalJSONString(field.key)
+		if err != nil {
+			return "", err
+		}
+		sb.Write(key)
+		sb.WriteByte(':')
+		sb.Write(field.value)
+	}
+	sb.WriteByte('}')
+	return sb.String(), nil
+}
+
+type jsonField struct {
+	key   string
+	value json.RawMessage
+}
+
+// jsonObjectFields returns the fields of a JSON object in their order, with
+// their raw values, or false if the message isn't a JSON object.
+func jsonObjectFields(message string) ([]jsonField, bool) {
+	dec := json.NewDecoder(strings.NewReader(message))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+	fields := []jsonField{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, false
+		}
+		fields = append(fields, jsonField{key: key, value: value})
+	}
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('}') {
+		return nil, false
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, false
+	}
+	return fields, true
+}
+
+// marshalJSONString encodes a JSON string without escaping the HTML
+// characters, like <, > and &, which json.Marshal does.
+func marshalJSONString(s string) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
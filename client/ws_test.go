@@ -0,0 +1,230 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/guregu/null.v3"
+
+	k6ws "go.k6.io/k6/js/modules/k6/ws"
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/testutils/httpmultibin"
+	"go.k6.io/k6/metrics"
+)
+
+// wsTest is a traced WebSocket client in a VU, connecting to a server that
+// records the handshake headers and the received messages.
+type wsTest struct {
+	rt         *modulestest.Runtime
+	tb         *httpmultibin.HTTPMultiBin
+	samples    chan metrics.SampleContainer
+	handshakes chan http.Header
+	messages   chan []byte
+}
+
+func newWSTest(t *testing.T, opts WSOptions) *wsTest {
+	t.Helper()
+
+	wt := &wsTest{
+		tb:         httpmultibin.NewHTTPMultiBin(t),
+		samples:    make(chan metrics.SampleContainer, 1000),
+		handshakes: make(chan http.Header, 1),
+		messages:   make(chan []byte, 10),
+	}
+	wt.tb.Mux.HandleFunc("/ws-traced", func(w http.ResponseWriter, r *http.Request) {
+		wt.handshakes <- r.Header.Clone()
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			wt.messages <- msg
+		}
+	})
+
+	registry := metrics.NewRegistry()
+	opts.MessageMetric = registry.MustNewMetric("tracing_ws_messages", metrics.Counter)
+
+	wt.rt = modulestest.NewRuntime(t)
+	wsModule, ok := k6ws.New().NewModuleInstance(wt.rt.VU).(*k6ws.WS)
+	require.True(t, ok)
+	require.NoError(t, wt.rt.VU.Runtime().Set("ws", NewWS(wt.rt.VU, wsModule, opts)))
+
+	root, err := lib.NewGroup("", nil)
+	require.NoError(t, err)
+	wt.rt.MoveToVUContext(&lib.State{
+		Group:          root,
+		Dialer:         wt.tb.Dialer,
+		TLSConfig:      wt.tb.TLSClientConfig,
+		Samples:        wt.samples,
+		Options:        lib.Options{UserAgent: null.StringFrom("k6-test"), SystemTags: metrics.NewSystemTagSet(metrics.TagURL)},
+		BuiltinMetrics: metrics.RegisterBuiltinMetrics(registry),
+		Tags:           lib.NewVUStateTags(registry.RootTagSet()),
+	})
+	return wt
+}
+
+func TestWSConnectIsTraced(t *testing.T) {
+	t.Parallel()
+
+	wt := newWSTest(t, WSOptions{
+		Options:      Options{Propagator: PropagatorW3C},
+		MessageSpans: true,
+		TraceField:   "traceparent",
+		DataField:    "payload",
+	})
+	rt, tb, samples, handshakes, messages := wt.rt, wt.tb, wt.samples, wt.handshakes, wt.messages
+
+	val, err := rt.VU.Runtime().RunString(tb.Replacer.Replace(`
+		var res = ws.connect("WSBIN_URL/ws-traced", {headers: {"X-Custom": "value"}}, function(socket) {
+			socket.on("open", function() {
+				socket.send("hello");
+				socket.send(JSON.stringify({type: "greeting"}));
+				socket.close();
+			});
+		});
+		res.trace_id;
+	`))
+	require.NoError(t, err)
+	traceID := val.String()
+	require.Len(t, traceID, 32)
+
+	handshake := <-handshakes
+	assert.Equal(t, "value", handshake.Get("X-Custom"))
+	assert.Regexp(t, "^00-"+traceID+"-[0-9a-f]{16}-01$", handshake.Get(HeaderNameW3C))
+
+	var first, second map[string]interface{}
+	require.NoError(t, json.Unmarshal(<-messages, &first))
+	require.NoError(t, json.Unmarshal(<-messages, &second))
+	assert.Equal(t, "hello", first["payload"])
+	assert.Equal(t, "greeting", second["type"])
+	assert.Regexp(t, "^00-"+traceID+"-[0-9a-f]{16}-01$", first["traceparent"])
+	assert.NotEqual(t, first["traceparent"], second["traceparent"], "each message should have its own span")
+
+	close(samples)
+	var sessions, messageSpans int
+	var sessionSpanID string
+	var parentSpanIDs []string
+	for container := range samples {
+		for _, sample := range container.GetSamples() {
+			switch sample.Metric.Name {
+			case metrics.WSSessionDurationName:
+				assert.Equal(t, traceID, sample.Metadata["trace_id"])
+				sessionSpanID = sample.Metadata["span_id"]
+				sessions++
+			case "tracing_ws_messages":
+				assert.Equal(t, traceID, sample.Metadata["trace_id"])
+				parentSpanIDs = append(parentSpanIDs, sample.Metadata["parent_span_id"])
+				messageSpans++
+			}
+		}
+	}
+	assert.Equal(t, 1, sessions)
+	assert.Equal(t, 2, messageSpans)
+	assert.Equal(t, []string{sessionSpanID, sessionSpanID}, parentSpanIDs)
+	assert.Regexp(t, "^00-"+traceID+"-"+sessionSpanID+"-01$", handshake.Get(HeaderNameW3C))
+}
+
+func TestWSMessageTraceFieldFollowsPropagator(t *testing.T) {
+	t.Parallel()
+
+	for propagator, pattern := range map[string]string{
+		PropagatorW3C:    "^00-%s-[0-9a-f]{16}-01$",
+		PropagatorB3:     "^%s-[0-9a-f]{16}-1$",
+		PropagatorJaeger: "^%s:[0-9a-f]{16}:0:1$",
+	} {
+		wt := newWSTest(t, WSOptions{
+			Options:      Options{Propagator: propagator},
+			MessageSpans: true,
+			DataField:    "data",
+		})
+		val, err := wt.rt.VU.Runtime().RunString(wt.tb.Replacer.Replace(`
+			var res = ws.connect("WSBIN_URL/ws-traced", function(socket) {
+				socket.on("open", function() {
+					socket.send("hello");
+					socket.close();
+				});
+			});
+			res.trace_id;
+		`))
+		require.NoError(t, err)
+
+		headerName, err := HeaderNameForPropagator(propagator)
+		require.NoError(t, err)
+		var message map[string]interface{}
+		require.NoError(t, json.Unmarshal(<-wt.messages, &message))
+		assert.Len(t, message, 2, propagator)
+		assert.Equal(t, "hello", message["data"], propagator)
+		assert.Regexp(t, fmt.Sprintf(pattern, val.String()), message[headerName], propagator)
+	}
+}
+
+func TestWrapWSMessage(t *testing.T) {
+	t.Parallel()
+
+	wrapped, err := wrapWSMessage("plain", "traceparent", "data", "ctx")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"data": "plain", "traceparent": "ctx"}`, wrapped)
+
+	wrapped, err = wrapWSMessage(`{"id": 1}`, "tp", "data", "ctx")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id": 1, "tp": "ctx"}`, wrapped)
+
+	wrapped, err = wrapWSMessage(`[1, 2]`, "traceparent", "data", "ctx")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"data": "[1, 2]", "traceparent": "ctx"}`, wrapped)
+
+	// The payload of the script is kept as it is: large integers, HTML
+	// characters and the order of the fields.
+	wrapped, err = wrapWSMessage(`{"z": 9007199254740993, "html": "<a&b>", "a": {"n": 1.50}}`, "tp", "data", "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, `{"z":9007199254740993,"html":"<a&b>","a":{"n": 1.50},"tp":"ctx"}`, wrapped)
+
+	wrapped, err = wrapWSMessage(`{"tp": "old", "id": 1}`, "tp", "data", "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, `{"tp":"ctx","id":1}`, wrapped)
+
+	wrapped, err = wrapWSMessage(`<b> & "quoted"`, "tp", "data", "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, `{"data":"<b> & \"quoted\"","tp":"ctx"}`, wrapped)
+
+	wrapped, err = wrapWSMessage(`{"id": 1} trailing`, "tp", "data", "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, `{"data":"{\"id\": 1} trailing","tp":"ctx"}`, wrapped)
+}
+
+func TestWSMessagesOfAnUnsampledConnection(t *testing.T) {
+	t.Parallel()
+
+	const upstream = "00-fedcba9876543210fedcba9876543210-fedcba9876543210-00"
+	wt := newWSTest(t, WSOptions{
+		Options:      Options{Propagator: PropagatorW3C, HeaderMode: HeaderModeChild},
+		MessageSpans: true,
+		DataField:    "data",
+	})
+	_, err := wt.rt.VU.Runtime().RunString(wt.tb.Replacer.Replace(`
+		ws.connect("WSBIN_URL/ws-traced", {headers: {"traceparent": "` + upstream + `"}}, function(socket) {
+			socket.on("open", function() {
+				socket.send("hello");
+				socket.close();
+			});
+		});
+	`))
+	require.NoError(t, err)
+
+	assert.Regexp(t, "^00-fedcba9876543210fedcba9876543210-[0-9a-f]{16}-00$", (<-wt.handshakes).Get(HeaderNameW3C))
+	var message map[string]interface{}
+	require.NoError(t, json.Unmarshal(<-wt.messages, &message))
+	assert.Regexp(t, "^00-fedcba9876543210fedcba9876543210-[0-9a-f]{16}-00$", message[HeaderNameW3C])
+}
@@ -0,0 +1,149 @@
+package crocospans
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Auth modes of the push requests.
+const (
+	AuthModeNone   = "none"
+	AuthModeBasic  = "basic"
+	AuthModeBearer = "bearer"
+	AuthModeHeader = "header"
+)
+
+// parseAuthConfig parses and validates the auth options. Without an explicit
+// auth mode, a configured token is sent as a bearer token, and no auth is
+// used otherwise.
+func parseAuthConfig(cfg *Config, layers *configLayers, spec outputSpec, env map[string]string) error {
+	layers.string("authMode", &cfg.AuthMode)
+	layers.string("authHeader", &cfg.AuthHeader)
+	layers.string("username", &cfg.Username)
+	layers.string("token", &cfg.Token)
+	layers.string("tokenFile", &cfg.TokenFile)
+	layers.string("tenantHeader", &cfg.TenantHeader)
+	layers.string("tenantID", &cfg.TenantID)
+
+	if spec.authDefaults != nil {
+		if err := spec.authDefaults(cfg, layers, env); err != nil {
+			return err
+		}
+	}
+	if cfg.AuthMode == "" {
+		cfg.AuthMode = AuthModeNone
+		if cfg.Token != "" || cfg.TokenFile != "" {
+			cfg.AuthMode = AuthModeBearer
+		}
+	}
+
+	switch cfg.AuthMode {
+	case AuthModeNone:
+	case AuthModeBasic, AuthModeBearer, AuthModeHeader:
+		if cfg.Token == "" && cfg.TokenFile == "" {
+			return layers.validationError("authMode", "'%s' needs a token or a tokenFile", cfg.AuthMode)
+		}
+		if cfg.Token != "" && cfg.TokenFile != "" {
+			return layers.validationError("tokenFile", "can't be used together with a token")
+		}
+		if cfg.AuthMode == AuthModeHeader && cfg.AuthHeader == "" {
+			return layers.validationError("authMode", "'%s' needs an authHeader", cfg.AuthMode)
+		}
+	default:
+		return layers.validationError("authMode", "should be %s, %s, %s or %s but was '%s'",
+			AuthModeNone, AuthModeBasic, AuthModeBearer, AuthModeHeader, cfg.AuthMode)
+	}
+
+	if cfg.TenantHeader != "" && cfg.TenantID == "" {
+		return layers.validationError("tenantHeader", "needs a tenantID")
+	}
+	return nil
+}
+
+// authenticator adds the configured credentials and tenant header to the
+// push requests.
+type authenticator struct {
+	mode         string
+	header       string
+	username     string
+	token        *tokenSource
+	tenantHeader string
+	tenantID     string
+}
+
+func newAuthenticator(conf Config) *authenticator {
+	return &authenticator{
+		mode:         conf.AuthMode,
+		header:       conf.AuthHeader,
+		username:     conf.Username,
+		token:        &tokenSource{token: conf.Token, path: conf.TokenFile},
+		tenantHeader: conf.TenantHeader,
+		tenantID:     conf.TenantID,
+	}
+}
+
+func (a *authenticator) authenticate(rq *http.Request) error {
+	if a.tenantHeader != "" {
+		rq.Header.Set(a.tenantHeader, a.tenantID)
+	}
+	if a.mode == AuthModeNone || a.mode == "" {
+		return nil
+	}
+
+	token, err := a.token.get()
+	if err != nil {
+		return err
+	}
+	switch a.mode {
+	case AuthModeBasic:
+		rq.SetBasicAuth(a.username, token)
+	case AuthModeBearer:
+		rq.Header.Set("Authorization", "Bearer "+token)
+	case AuthModeHeader:
+		rq.Header.Set(a.header, token)
+	}
+	return nil
+}
+
+// tokenSource returns either a static token, or the contents of a token file.
+// The file is read again whenever its modification time or size changes.
+type tokenSource struct {
+	token string
+	path  string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+func (s *tokenSource) get() (string, error) {
+	if s.path == "" {
+		return s.token, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the token file: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size && s.token != "" {
+		return s.token, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the token file: %w", err)
+	}
+	token := string(bytes.TrimSpace(data))
+	if token == "" {
+		return "", fmt.Errorf("the token file '%s' is empty", s.path)
+	}
+	s.token, s.modTime, s.size = token, info.ModTime(), info.Size()
+	return s.token, nil
+}
@@ -0,0 +1,152 @@
+package crocospans
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.k6.io/k6/lib/testutils"
+	"go.k6.io/k6/output"
+)
+
+func TestAuthModes(t *testing.T) {
+	t.Parallel()
+
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	newOTLP := func(env map[string]string) *Output {
+		o, err := NewOTLP(output.Params{
+			ConfigArgument: srv.URL,
+			Environment:    env,
+			Logger:         testutils.NewLogger(t),
+		})
+		require.NoError(t, err)
+		return o
+	}
+
+	// The crocospans output keeps its basic auth and tenant header by default.
+	o := newTestOutput(t, srv.URL, nil)
+	require.NoError(t, o.push([]byte("batch")))
+	user, pass, ok := (&http.Request{Header: got}).BasicAuth()
+	require.True(t, ok)
+	assert.Equal(t, "1", user)
+	assert.Equal(t, "token", pass)
+	assert.Equal(t, "1", got.Get("X-Scope-OrgID"))
+
+	// The tenant header can be disabled.
+	o = newTestOutput(t, srv.URL, map[string]string{"XK6_CROCOSPANS_TENANT_HEADER": ""})
+	require.NoError(t, o.push([]byte("batch")))
+	assert.Empty(t, got.Get("X-Scope-OrgID"))
+
+	// The other outputs don't authenticate without a token, and send it as a
+	// bearer token by default.
+	o = newOTLP(nil)
+	require.NoError(t, o.push([]byte("batch")))
+	assert.Empty(t, got.Get("Authorization"))
+
+	o = newOTLP(map[string]string{"XK6_OTLP_TOKEN": "secret"})
+	require.NoError(t, o.push([]byte("batch")))
+	assert.Equal(t, "Bearer secret", got.Get("Authorization"))
+
+	o = newOTLP(map[string]string{
+		"XK6_OTLP_AUTH_MODE":     "header",
+		"XK6_OTLP_AUTH_HEADER":   "X-API-Key",
+		"XK6_OTLP_TOKEN":         "secret",
+		"XK6_OTLP_TENANT_HEADER": "X-Tenant",
+		"XK6_OTLP_TENANT_ID":     "team-a",
+	})
+	require.NoError(t, o.push([]byte("batch")))
+	assert.Empty(t, got.Get("Authorization"))
+	assert.Equal(t, "secret", got.Get("X-API-Key"))
+	assert.Equal(t, "team-a", got.Get("X-Tenant"))
+}
+
+func TestAuthTokenFileRotation(t *testing.T) {
+	t.Parallel()
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("first\n"), 0o600))
+
+	o := newTestOutput(t, srv.URL, map[string]string{
+		"XK6_CROCOSPANS_AUTH_MODE":  "bearer",
+		"XK6_CROCOSPANS_TOKEN":      "",
+		"XK6_CROCOSPANS_TOKEN_FILE": tokenFile,
+	})
+	require.NoError(t, o.push([]byte("batch")))
+	assert.Equal(t, "Bearer first", got)
+
+	require.NoError(t, os.WriteFile(tokenFile, []byte("second\n"), 0o600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(tokenFile, later, later))
+	require.NoError(t, o.push([]byte("batch")))
+	assert.Equal(t, "Bearer second", got)
+
+	require.NoError(t, os.Remove(tokenFile))
+	assert.Error(t, o.push([]byte("batch")))
+}
+
+func TestAuthConfigValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  string
+	}{
+		{
+			name: "UnknownMode",
+			env:  map[string]string{"XK6_OTLP_AUTH_MODE": "digest"},
+			err:  "authMode should be none, basic, bearer or header but was 'digest'",
+		},
+		{
+			name: "MissingToken",
+			env:  map[string]string{"XK6_OTLP_AUTH_MODE": "bearer"},
+			err:  "authMode 'bearer' needs a token or a tokenFile",
+		},
+		{
+			name: "MissingHeader",
+			env:  map[string]string{"XK6_OTLP_AUTH_MODE": "header", "XK6_OTLP_TOKEN": "secret"},
+			err:  "authMode 'header' needs an authHeader",
+		},
+		{
+			name: "TokenAndFile",
+			env:  map[string]string{"XK6_OTLP_TOKEN": "secret", "XK6_OTLP_TOKEN_FILE": "token"},
+			err:  "tokenFile can't be used together with a token",
+		},
+		{
+			name: "MissingTenant",
+			env:  map[string]string{"XK6_OTLP_TENANT_HEADER": "X-Tenant"},
+			err:  "tenantHeader needs a tenantID",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewOTLP(output.Params{
+				ConfigArgument: "http://localhost",
+				Environment:    tt.env,
+				Logger:         testutils.NewLogger(t),
+			})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
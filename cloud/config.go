@@ -1,66 +1,306 @@
 package crocospans
 
 import (
+	"crypto/rand"
 	"fmt"
+	"net/url"
 	"strconv"
 	"time"
 
 	"go.k6.io/k6/output"
 )
 
-// Config is the config for the crocospans output.
+// Config is the config for the crocospans output, as well as the other span
+// outputs that share its machinery.
 type Config struct {
 	Endpoint     string
 	PushInterval time.Duration
 	OrgID        int64
-	Token        string
+
+	// Profile is the kind of the crocospans endpoint, one of the Profile*
+	// constants, which determines the required options and auth defaults.
+	Profile string
+
+	// AuthMode is how the push requests are authenticated: one of the
+	// AuthMode* constants.
+	AuthMode string
+	// AuthHeader is the name of the header that carries the token in the
+	// header auth mode, e.g. X-API-Key.
+	AuthHeader string
+	// Username is the user of the basic auth mode.
+	Username string
+	Token    string
+	// TokenFile is a file with the token, which is read again whenever it
+	// changes, so credentials can be rotated during long tests.
+	TokenFile string
+	// TenantHeader is the name of an optional header that carries TenantID,
+	// e.g. X-Scope-OrgID for multi-tenant backends.
+	TenantHeader string
+	TenantID     string
+
+	// PhaseSpans enables the per-phase timing breakdown (connect, TLS,
+	// time to first byte, etc.) of every request span.
+	PhaseSpans bool
+
+	// TestRunID identifies the test run the spans belong to. It's taken from
+	// the k6 cloud environment when running there, or generated for local runs.
+	TestRunID string
+
+	// Sampling decides which traced requests are sent.
+	Sampling SamplingConfig
+
+	// Report configures the end-of-test report of the slowest and failed
+	// traced requests.
+	Report ReportConfig
+
+	// PushConcurrency is the number of goroutines that send batches to the
+	// endpoint in parallel, independently of the flush interval.
+	PushConcurrency int
+	// PushQueueSize is the maximum number of marshaled batches waiting for a
+	// free sender. Batches that don't fit in the queue are dropped.
+	PushQueueSize int
+	// PushRetries is the number of times a push that failed with a network
+	// error, a 429 or a 5xx response is retried, with an exponential backoff
+	// starting at PushRetryDelay.
+	PushRetries    int
+	PushRetryDelay time.Duration
+
+	// LogStatsInterval is how often a summary of the pushed spans is logged
+	// during the test, since the stats of the final flush don't reach the
+	// k6 metrics. Zero disables it.
+	LogStatsInterval time.Duration
+
+	// The options of the HTTP client used to push the spans.
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	ProxyURL           string
+	Timeout            time.Duration
+	// Headers are static headers added to every push request.
+	Headers map[string]string
 
 	// TODO: add other config fields?
 }
 
+// Endpoint profiles of the crocospans output.
+const (
+	// ProfileGrafanaCloud is a Grafana Cloud endpoint, which needs the org ID
+	// and the token of the stack.
+	ProfileGrafanaCloud = "grafana-cloud"
+	// ProfileGeneric is any other endpoint, e.g. a local receiver or a
+	// self-hosted Tempo, where the org ID and the token are optional.
+	ProfileGeneric = "generic"
+)
+
 // NewConfig creates a new Config instance from the provided output.Params
 func NewConfig(params output.Params) (Config, error) {
+	cfg, _, err := newConfig(params, outputSpec{
+		name:         "xk6-crocospans",
+		envPrefix:    "XK6_CROCOSPANS_",
+		options:      []string{"profile", "orgID"},
+		authDefaults: crocospansAuthDefaults,
+	})
+	return cfg, err
+}
+
+// crocospansAuthDefaults validates the options required by the endpoint
+// profile. The org ID, when set, is used for basic auth and the X-Scope-OrgID
+// tenant header, unless these are configured explicitly.
+func crocospansAuthDefaults(cfg *Config, layers *configLayers, env map[string]string) error {
+	cfg.Profile = ProfileGrafanaCloud
+	layers.string("profile", &cfg.Profile)
+	switch cfg.Profile {
+	case ProfileGrafanaCloud, ProfileGeneric:
+	default:
+		return layers.validationError("profile", "should be %s or %s but was '%s'",
+			ProfileGrafanaCloud, ProfileGeneric, cfg.Profile)
+	}
+
+	_, hasOrgID := layers.get("orgID")
+	if !hasOrgID {
+		if cfg.Profile == ProfileGrafanaCloud {
+			return fmt.Errorf("XK6_CROCOSPANS_ORG_ID is required for the %s profile", ProfileGrafanaCloud)
+		}
+		return nil
+	}
+	if err := layers.int64("orgID", &cfg.OrgID); err != nil {
+		return err
+	}
+	orgID := strconv.FormatInt(cfg.OrgID, 10)
+
+	if _, ok := layers.get("tenantHeader"); !ok {
+		cfg.TenantHeader = "X-Scope-OrgID"
+	}
+	if _, ok := layers.get("tenantID"); !ok {
+		cfg.TenantID = orgID
+	}
+	if _, ok := layers.get("username"); !ok {
+		cfg.Username = orgID
+	}
+
+	if cfg.Token == "" && cfg.TokenFile == "" {
+		if val, ok := env["K6_CLOUD_TOKEN"]; ok {
+			cfg.Token = val
+		}
+	}
+	if cfg.AuthMode == "" && (cfg.Profile == ProfileGrafanaCloud || cfg.Token != "" || cfg.TokenFile != "") {
+		cfg.AuthMode = AuthModeBasic
+	}
+	if cfg.Profile == ProfileGrafanaCloud && cfg.Token == "" && cfg.TokenFile == "" {
+		return fmt.Errorf("XK6_CROCOSPANS_TOKEN or K6_CLOUD_TOKEN is required for the %s profile", ProfileGrafanaCloud)
+	}
+	return nil
+}
+
+// newConfig parses the options that are common for all span outputs, from
+// all config sources. The returned layers can be used to parse the
+// output-specific options from the spec.
+func newConfig(params output.Params, spec outputSpec) (Config, *configLayers, error) {
 	cfg := Config{
 		// TODO: add default Endpoint value
-		PushInterval: 1 * time.Second,
+		PushInterval:     1 * time.Second,
+		PushConcurrency:  4,
+		PushQueueSize:    100,
+		PushRetries:      2,
+		PushRetryDelay:   500 * time.Millisecond,
+		LogStatsInterval: time.Minute,
+		Timeout:          10 * time.Second,
 	}
-
-	if params.ConfigArgument != "" {
-		cfg.Endpoint = params.ConfigArgument
-	} else if val, ok := params.Environment["XK6_CROCOSPANS_ENDPOINT"]; ok {
-		cfg.Endpoint = val
+	if spec.argOption == "" {
+		spec.argOption = "endpoint"
 	}
-	if cfg.Endpoint == "" {
-		return cfg, fmt.Errorf("missing crocospans endpoint, use '--out xk6-crocospans=http://endpoint' or the XK6_CROCOSPANS_ENDPOINT env var")
+
+	options := append([]string{
+		"endpoint", "pushInterval", "pushConcurrency", "pushQueueSize", "pushRetries", "pushRetryDelay", "logStatsInterval",
+		"phaseSpans", "testRunID", "sampleRate", "sampleKeepErrors", "sampleSlowerThan", "sampleRules",
+		"reportTop", "reportFailed", "reportFile",
+		"caFile", "certFile", "keyFile", "insecureSkipVerify", "proxyURL", "timeout", "headers",
+		"authMode", "authHeader", "username", "token", "tokenFile", "tenantHeader", "tenantID",
+	}, spec.options...)
+	layers, err := newConfigLayers(params, spec, options)
+	if err != nil {
+		return cfg, nil, err
 	}
 
-	if val, ok := params.Environment["XK6_CROCOSPANS_PUSH_INTERVAL"]; ok {
-		var err error
-		cfg.PushInterval, err = time.ParseDuration(val)
-		if err != nil {
-			return cfg, fmt.Errorf("error parsing environment variable 'XK6_CROCOSPANS_PUSH_INTERVAL': %w", err)
+	if spec.argOption == "endpoint" {
+		layers.string("endpoint", &cfg.Endpoint)
+		if cfg.Endpoint == "" {
+			return cfg, nil, fmt.Errorf("missing %s endpoint, use '--out %s=http://endpoint' or the %sENDPOINT env var",
+				spec.name, spec.name, spec.envPrefix)
 		}
 	}
 
-	if val, ok := params.Environment["XK6_CROCOSPANS_ORG_ID"]; ok {
-		var err error
-		cfg.OrgID, err = strconv.ParseInt(val, 10, 64)
-		if err != nil {
-			return cfg, fmt.Errorf("error parsing environment variable 'XK6_CROCOSPANS_ORG_ID': %w", err)
+	if err := layers.duration("pushInterval", &cfg.PushInterval); err != nil {
+		return cfg, nil, err
+	}
+
+	if err := layers.int("pushConcurrency", &cfg.PushConcurrency); err != nil {
+		return cfg, nil, err
+	}
+	if cfg.PushConcurrency < 1 {
+		return cfg, nil, layers.validationError("pushConcurrency", "should be positive but was %d", cfg.PushConcurrency)
+	}
+
+	if err := layers.int("pushQueueSize", &cfg.PushQueueSize); err != nil {
+		return cfg, nil, err
+	}
+	if cfg.PushQueueSize < 0 {
+		return cfg, nil, layers.validationError("pushQueueSize", "should not be negative but was %d", cfg.PushQueueSize)
+	}
+
+	if err := layers.int("pushRetries", &cfg.PushRetries); err != nil {
+		return cfg, nil, err
+	}
+	if cfg.PushRetries < 0 {
+		return cfg, nil, layers.validationError("pushRetries", "should not be negative but was %d", cfg.PushRetries)
+	}
+	if err := layers.duration("pushRetryDelay", &cfg.PushRetryDelay); err != nil {
+		return cfg, nil, err
+	}
+	if err := layers.duration("logStatsInterval", &cfg.LogStatsInterval); err != nil {
+		return cfg, nil, err
+	}
+	if cfg.LogStatsInterval < 0 {
+		return cfg, nil, layers.validationError("logStatsInterval", "should not be negative but was %s", cfg.LogStatsInterval)
+	}
+
+	if err := layers.bool("phaseSpans", &cfg.PhaseSpans); err != nil {
+		return cfg, nil, err
+	}
+
+	if cfg.Sampling, err = parseSamplingConfig(layers); err != nil {
+		return cfg, nil, err
+	}
+	if cfg.Report, err = parseReportConfig(layers); err != nil {
+		return cfg, nil, err
+	}
+
+	layers.string("caFile", &cfg.CAFile)
+	layers.string("certFile", &cfg.CertFile)
+	layers.string("keyFile", &cfg.KeyFile)
+	switch {
+	case cfg.CertFile != "" && cfg.KeyFile == "":
+		return cfg, nil, layers.validationError("certFile", "needs keyFile to be set too, for the client certificate")
+	case cfg.KeyFile != "" && cfg.CertFile == "":
+		return cfg, nil, layers.validationError("keyFile", "needs certFile to be set too, for the client certificate")
+	}
+	if err := layers.bool("insecureSkipVerify", &cfg.InsecureSkipVerify); err != nil {
+		return cfg, nil, err
+	}
+	layers.string("proxyURL", &cfg.ProxyURL)
+	if cfg.ProxyURL != "" {
+		if _, err := url.Parse(cfg.ProxyURL); err != nil {
+			return cfg, nil, layers.invalid("proxyURL", cfg.ProxyURL, err)
 		}
-	} else {
-		return cfg, fmt.Errorf("XK6_CROCOSPANS_ORG_ID is required")
+	}
+	if err := layers.duration("timeout", &cfg.Timeout); err != nil {
+		return cfg, nil, err
+	}
+	if err := layers.headers("headers", &cfg.Headers); err != nil {
+		return cfg, nil, err
+	}
+
+	if err := parseAuthConfig(&cfg, layers, spec, params.Environment); err != nil {
+		return cfg, nil, err
 	}
 
-	if val, ok := params.Environment["XK6_CROCOSPANS_TOKEN"]; ok {
-		cfg.Token = val
-	} else if val, ok := params.Environment["K6_CLOUD_TOKEN"]; ok {
-		cfg.Token = val
-	} else {
-		return cfg, fmt.Errorf("XK6_CROCOSPANS_TOKEN or K6_CLOUD_TOKEN is required")
+	testRunID, err := resolveTestRunID(layers, params.Environment)
+	if err != nil {
+		return cfg, nil, err
 	}
+	cfg.TestRunID = testRunID
 
-	// TODO: add more validation and options
+	return cfg, layers, nil
+}
+
+// testRunIDEnvVars are the environment variables k6 cloud and the k6-operator
+// set to the ID of the test run, in order of precedence.
+var testRunIDEnvVars = []string{
+	"K6_CLOUDRUN_TEST_RUN_ID", // k6 cloud runs
+	"K6_CLOUD_TEST_RUN_ID",    // k6 cloud runs, in newer k6 versions
+	"K6_CLOUD_PUSH_REF_ID",    // the k6-operator, when the cloud output is used
+}
+
+// resolveTestRunID returns the explicitly configured test run ID, or the one
+// k6 cloud or the k6-operator exposes to the test run. For local runs, a
+// random UUID is generated.
+func resolveTestRunID(layers *configLayers, env map[string]string) (string, error) {
+	if val, _ := layers.get("testRunID"); val != "" {
+		return val, nil
+	}
+	for _, key := range testRunIDEnvVars {
+		if val := env[key]; val != "" {
+			return val, nil
+		}
+	}
 
-	return cfg, nil
+	// Version 4 UUID, see https://www.rfc-editor.org/rfc/rfc4122#section-4.4
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate a test run ID: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
 }
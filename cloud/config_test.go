@@ -0,0 +1,180 @@
+package crocospans
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.k6.io/k6/output"
+)
+
+func TestConfigLayering(t *testing.T) {
+	t.Parallel()
+
+	params := output.Params{
+		JSONConfig: []byte(`{"endpoint": "http://json", "pushInterval": "2s", "pushConcurrency": 2, "orgID": 1, "token": "json"}`),
+		Environment: map[string]string{
+			"XK6_CROCOSPANS_PUSH_INTERVAL":    "3s",
+			"XK6_CROCOSPANS_PUSH_CONCURRENCY": "3",
+		},
+	}
+
+	cfg, err := NewConfig(params)
+	require.NoError(t, err)
+	assert.Equal(t, "http://json", cfg.Endpoint)
+	assert.Equal(t, 3*time.Second, cfg.PushInterval)
+	assert.Equal(t, 3, cfg.PushConcurrency)
+	assert.Equal(t, int64(1), cfg.OrgID)
+	assert.Equal(t, "json", cfg.Token)
+	assert.Equal(t, 100, cfg.PushQueueSize)
+
+	params.ConfigArgument = "http://arg/push?tenant=a&pushInterval=5s&orgID=7"
+	cfg, err = NewConfig(params)
+	require.NoError(t, err)
+	assert.Equal(t, "http://arg/push?tenant=a", cfg.Endpoint)
+	assert.Equal(t, 5*time.Second, cfg.PushInterval)
+	assert.Equal(t, 3, cfg.PushConcurrency)
+	assert.Equal(t, int64(7), cfg.OrgID)
+}
+
+func TestConfigLargeJSONNumbers(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig(output.Params{
+		JSONConfig: []byte(`{"endpoint": "http://json", "pushQueueSize": 1000000, "orgID": 1234567, "token": "json"}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1000000, cfg.PushQueueSize)
+	assert.Equal(t, int64(1234567), cfg.OrgID)
+
+	_, layers, err := newConfig(output.Params{
+		JSONConfig: []byte(`{"path": "spans.ndjson", "maxSize": 10485760}`),
+	}, fileOutputSpec)
+	require.NoError(t, err)
+	fileCfg, err := newFileConfig(layers)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10485760), fileCfg.MaxSize)
+}
+
+func TestConfigHeadersFromJSONObject(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig(output.Params{
+		JSONConfig: []byte(`{"endpoint": "http://json", "orgID": 1, "token": "json",
+			"headers": {"X-API-Key": "a,b=c d", "X-Retries": 3}}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"X-API-Key": "a,b=c d", "X-Retries": "3"}, cfg.Headers)
+
+	cfg, err = NewConfig(output.Params{
+		JSONConfig: []byte(`{"endpoint": "http://json", "orgID": 1, "token": "json",
+			"headers": {"Authorization": "Basic dXNlcjpw+c3M=", "X-Key": "a%2Bb,c"}}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Authorization": "Basic dXNlcjpw+c3M=", "X-Key": "a%2Bb,c"}, cfg.Headers)
+
+	for _, jsonConf := range []string{
+		`{"endpoint": "http://json", "headers": {"X-Nested": {"a": "b"}}}`,
+		`{"endpoint": "http://json", "headers": {"X=Key": "a"}}`,
+		`{"endpoint": "http://json", "pushInterval": {"a": "b"}}`,
+	} {
+		_, err := NewConfig(output.Params{JSONConfig: []byte(jsonConf)})
+		assert.ErrorContains(t, err, "invalid value of option", jsonConf)
+	}
+}
+
+func TestConfigErrorsNameTheSource(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		params output.Params
+		err    string
+	}{
+		{
+			name:   "JSON",
+			params: output.Params{JSONConfig: []byte(`{"endpoint": "http://json", "pushInterval": "soon"}`)},
+			err:    "invalid pushInterval 'soon' in the JSON config",
+		},
+		{
+			name:   "UnknownJSONOption",
+			params: output.Params{JSONConfig: []byte(`{"endpoint": "http://json", "pushIntervals": "1s"}`)},
+			err:    "unknown option 'pushIntervals' in the xk6-crocospans JSON config",
+		},
+		{
+			name: "Environment",
+			params: output.Params{
+				ConfigArgument: "http://arg",
+				Environment:    map[string]string{"XK6_CROCOSPANS_PUSH_QUEUE_SIZE": "-1"},
+			},
+			err: "pushQueueSize should not be negative but was -1 (set by environment variable 'XK6_CROCOSPANS_PUSH_QUEUE_SIZE')",
+		},
+		{
+			name: "CertFileWithoutKeyFile",
+			params: output.Params{
+				ConfigArgument: "http://arg",
+				Environment:    map[string]string{"XK6_CROCOSPANS_CERT_FILE": "client.crt"},
+			},
+			err: "certFile needs keyFile to be set too, for the client certificate (set by environment variable 'XK6_CROCOSPANS_CERT_FILE')",
+		},
+		{
+			name:   "KeyFileWithoutCertFile",
+			params: output.Params{ConfigArgument: "http://arg?keyFile=client.key"},
+			err:    "keyFile needs certFile to be set too, for the client certificate (set by the '--out xk6-crocospans' argument)",
+		},
+		{
+			name:   "Argument",
+			params: output.Params{ConfigArgument: "http://arg?pushConcurrency=none"},
+			err:    "invalid pushConcurrency 'none' in the '--out xk6-crocospans' argument",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewConfig(tt.params)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestEnvOptionName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "PUSH_INTERVAL", envOptionName("pushInterval"))
+	assert.Equal(t, "ORG_ID", envOptionName("orgID"))
+	assert.Equal(t, "TEST_RUN_ID", envOptionName("testRunID"))
+	assert.Equal(t, "PATH", envOptionName("path"))
+}
+
+func TestConfigHeadersKeepPlusSigns(t *testing.T) {
+	t.Parallel()
+
+	// A '+' is sent as it is, e.g. in base64 tokens. In the query of the
+	// --out argument, it has to be escaped like in any URL query.
+	for _, params := range []output.Params{
+		{
+			ConfigArgument: "http://localhost",
+			Environment: map[string]string{
+				"XK6_CROCOSPANS_ORG_ID":  "1",
+				"XK6_CROCOSPANS_TOKEN":   "token",
+				"XK6_CROCOSPANS_HEADERS": "X-Api-Key=ab+cd/ef==,X-Gateway=k6%20tests",
+			},
+		},
+		{
+			ConfigArgument: "http://localhost?headers=X-Api-Key%3Dab%2Bcd%2Fef%3D%3D%2CX-Gateway%3Dk6%2520tests",
+			Environment: map[string]string{
+				"XK6_CROCOSPANS_ORG_ID": "1",
+				"XK6_CROCOSPANS_TOKEN":  "token",
+			},
+		},
+	} {
+		cfg, err := NewConfig(params)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"X-Api-Key": "ab+cd/ef==", "X-Gateway": "k6 tests"}, cfg.Headers)
+	}
+}
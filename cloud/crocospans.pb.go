@@ -91,12 +91,32 @@ type Request struct {
 	StartTimeUnixNano uint64 `protobuf:"fixed64,1,opt,name=StartTimeUnixNano,proto3" json:"StartTimeUnixNano,omitempty"`
 	EndTimeUnixNano   uint64 `protobuf:"fixed64,2,opt,name=EndTimeUnixNano,proto3" json:"EndTimeUnixNano,omitempty"`
 	TraceID           string `protobuf:"bytes,3,opt,name=TraceID,proto3" json:"TraceID,omitempty"`
-	TestRunID         int64  `protobuf:"varint,4,opt,name=TestRunID,proto3" json:"TestRunID,omitempty"`
-	Scenario          string `protobuf:"bytes,5,opt,name=Scenario,proto3" json:"Scenario,omitempty"`
-	Group             string `protobuf:"bytes,6,opt,name=Group,proto3" json:"Group,omitempty"`
-	HTTPUrl           string `protobuf:"bytes,7,opt,name=HTTPUrl,proto3" json:"HTTPUrl,omitempty"`
-	HTTPMethod        string `protobuf:"bytes,8,opt,name=HTTPMethod,proto3" json:"HTTPMethod,omitempty"`
-	HTTPStatus        int64  `protobuf:"varint,9,opt,name=HTTPStatus,proto3" json:"HTTPStatus,omitempty"`
+	// TestRunID is the numeric ID of k6 cloud test runs, which the crocospans
+	// backend reads, and 0 for the other runs.
+	TestRunID int64 `protobuf:"varint,4,opt,name=TestRunID,proto3" json:"TestRunID,omitempty"`
+	// TestRunIDString is the test run ID as it was resolved, e.g. the UUID
+	// generated for a local run.
+	TestRunIDString  string            `protobuf:"bytes,10,opt,name=TestRunIDString,proto3" json:"TestRunIDString,omitempty"`
+	Scenario         string            `protobuf:"bytes,5,opt,name=Scenario,proto3" json:"Scenario,omitempty"`
+	Group            string            `protobuf:"bytes,6,opt,name=Group,proto3" json:"Group,omitempty"`
+	HTTPUrl          string            `protobuf:"bytes,7,opt,name=HTTPUrl,proto3" json:"HTTPUrl,omitempty"`
+	HTTPMethod       string            `protobuf:"bytes,8,opt,name=HTTPMethod,proto3" json:"HTTPMethod,omitempty"`
+	HTTPStatus       int64             `protobuf:"varint,9,opt,name=HTTPStatus,proto3" json:"HTTPStatus,omitempty"`
+	SpanID           string            `protobuf:"bytes,11,opt,name=SpanID,proto3" json:"SpanID,omitempty"`
+	VUID             int64             `protobuf:"varint,12,opt,name=VUID,proto3" json:"VUID,omitempty"`
+	Iteration        int64             `protobuf:"varint,13,opt,name=Iteration,proto3" json:"Iteration,omitempty"`
+	ExpectedResponse bool              `protobuf:"varint,14,opt,name=ExpectedResponse,proto3" json:"ExpectedResponse,omitempty"`
+	Error            string            `protobuf:"bytes,15,opt,name=Error,proto3" json:"Error,omitempty"`
+	ErrorCode        int64             `protobuf:"varint,16,opt,name=ErrorCode,proto3" json:"ErrorCode,omitempty"`
+	Proto            string            `protobuf:"bytes,17,opt,name=Proto,proto3" json:"Proto,omitempty"`
+	TLSVersion       string            `protobuf:"bytes,18,opt,name=TLSVersion,proto3" json:"TLSVersion,omitempty"`
+	Name             string            `protobuf:"bytes,19,opt,name=Name,proto3" json:"Name,omitempty"`
+	RequestBytes     int64             `protobuf:"varint,20,opt,name=RequestBytes,proto3" json:"RequestBytes,omitempty"`
+	Tags             map[string]string `protobuf:"bytes,21,rep,name=Tags,proto3" json:"Tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Phases           []*Phase          `protobuf:"bytes,22,rep,name=Phases,proto3" json:"Phases,omitempty"`
+	Links            []*Link           `protobuf:"bytes,23,rep,name=Links,proto3" json:"Links,omitempty"`
+	ParentSpanID     string            `protobuf:"bytes,24,opt,name=ParentSpanID,proto3" json:"ParentSpanID,omitempty"`
+	ResponseBytes    int64             `protobuf:"varint,25,opt,name=ResponseBytes,proto3" json:"ResponseBytes,omitempty"`
 }
 
 func (x *Request) Reset() {
@@ -159,6 +179,13 @@ func (x *Request) GetTestRunID() int64 {
 	return 0
 }
 
+func (x *Request) GetTestRunIDString() string {
+	if x != nil {
+		return x.TestRunIDString
+	}
+	return ""
+}
+
 func (x *Request) GetScenario() string {
 	if x != nil {
 		return x.Scenario
@@ -194,6 +221,237 @@ func (x *Request) GetHTTPStatus() int64 {
 	return 0
 }
 
+func (x *Request) GetSpanID() string {
+	if x != nil {
+		return x.SpanID
+	}
+	return ""
+}
+
+func (x *Request) GetVUID() int64 {
+	if x != nil {
+		return x.VUID
+	}
+	return 0
+}
+
+func (x *Request) GetIteration() int64 {
+	if x != nil {
+		return x.Iteration
+	}
+	return 0
+}
+
+func (x *Request) GetExpectedResponse() bool {
+	if x != nil {
+		return x.ExpectedResponse
+	}
+	return false
+}
+
+func (x *Request) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Request) GetErrorCode() int64 {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return 0
+}
+
+func (x *Request) GetProto() string {
+	if x != nil {
+		return x.Proto
+	}
+	return ""
+}
+
+func (x *Request) GetTLSVersion() string {
+	if x != nil {
+		return x.TLSVersion
+	}
+	return ""
+}
+
+func (x *Request) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Request) GetRequestBytes() int64 {
+	if x != nil {
+		return x.RequestBytes
+	}
+	return 0
+}
+
+func (x *Request) GetTags() map[string]string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Request) GetPhases() []*Phase {
+	if x != nil {
+		return x.Phases
+	}
+	return nil
+}
+
+func (x *Request) GetLinks() []*Link {
+	if x != nil {
+		return x.Links
+	}
+	return nil
+}
+
+func (x *Request) GetParentSpanID() string {
+	if x != nil {
+		return x.ParentSpanID
+	}
+	return ""
+}
+
+func (x *Request) GetResponseBytes() int64 {
+	if x != nil {
+		return x.ResponseBytes
+	}
+	return 0
+}
+
+type Phase struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name              string `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	StartTimeUnixNano uint64 `protobuf:"fixed64,2,opt,name=StartTimeUnixNano,proto3" json:"StartTimeUnixNano,omitempty"`
+	EndTimeUnixNano   uint64 `protobuf:"fixed64,3,opt,name=EndTimeUnixNano,proto3" json:"EndTimeUnixNano,omitempty"`
+}
+
+func (x *Phase) Reset() {
+	*x = Phase{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crocospans_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Phase) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Phase) ProtoMessage() {}
+
+func (x *Phase) ProtoReflect() protoreflect.Message {
+	mi := &file_crocospans_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Phase.ProtoReflect.Descriptor instead.
+func (*Phase) Descriptor() ([]byte, []int) {
+	return file_crocospans_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Phase) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Phase) GetStartTimeUnixNano() uint64 {
+	if x != nil {
+		return x.StartTimeUnixNano
+	}
+	return 0
+}
+
+func (x *Phase) GetEndTimeUnixNano() uint64 {
+	if x != nil {
+		return x.EndTimeUnixNano
+	}
+	return 0
+}
+
+type Link struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	TraceID string `protobuf:"bytes,1,opt,name=TraceID,proto3" json:"TraceID,omitempty"`
+	SpanID  string `protobuf:"bytes,2,opt,name=SpanID,proto3" json:"SpanID,omitempty"`
+	Type    string `protobuf:"bytes,3,opt,name=Type,proto3" json:"Type,omitempty"`
+}
+
+func (x *Link) Reset() {
+	*x = Link{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crocospans_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Link) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Link) ProtoMessage() {}
+
+func (x *Link) ProtoReflect() protoreflect.Message {
+	mi := &file_crocospans_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Link.ProtoReflect.Descriptor instead.
+func (*Link) Descriptor() ([]byte, []int) {
+	return file_crocospans_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Link) GetTraceID() string {
+	if x != nil {
+		return x.TraceID
+	}
+	return ""
+}
+
+func (x *Link) GetSpanID() string {
+	if x != nil {
+		return x.SpanID
+	}
+	return ""
+}
+
+func (x *Link) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
 var File_crocospans_proto protoreflect.FileDescriptor
 
 var file_crocospans_proto_rawDesc = []byte{
@@ -206,7 +464,7 @@ var file_crocospans_proto_rawDesc = []byte{
 	0x6e, 0x74, 0x12, 0x2f, 0x0a, 0x08, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x73, 0x18, 0x04,
 	0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x63, 0x72, 0x6f, 0x63, 0x6f, 0x73, 0x70, 0x61, 0x6e,
 	0x73, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x52, 0x08, 0x52, 0x65, 0x71, 0x75, 0x65,
-	0x73, 0x74, 0x73, 0x22, 0xa5, 0x02, 0x0a, 0x07, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x73, 0x74, 0x73, 0x22, 0xf0, 0x06, 0x0a, 0x07, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
 	0x2c, 0x0a, 0x11, 0x53, 0x74, 0x61, 0x72, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x55, 0x6e, 0x69, 0x78,
 	0x4e, 0x61, 0x6e, 0x6f, 0x18, 0x01, 0x20, 0x01, 0x28, 0x06, 0x52, 0x11, 0x53, 0x74, 0x61, 0x72,
 	0x74, 0x54, 0x69, 0x6d, 0x65, 0x55, 0x6e, 0x69, 0x78, 0x4e, 0x61, 0x6e, 0x6f, 0x12, 0x28, 0x0a,
@@ -216,17 +474,66 @@ var file_crocospans_proto_rawDesc = []byte{
 	0x49, 0x44, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x54, 0x72, 0x61, 0x63, 0x65, 0x49,
 	0x44, 0x12, 0x1c, 0x0a, 0x09, 0x54, 0x65, 0x73, 0x74, 0x52, 0x75, 0x6e, 0x49, 0x44, 0x18, 0x04,
 	0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x54, 0x65, 0x73, 0x74, 0x52, 0x75, 0x6e, 0x49, 0x44, 0x12,
-	0x1a, 0x0a, 0x08, 0x53, 0x63, 0x65, 0x6e, 0x61, 0x72, 0x69, 0x6f, 0x18, 0x05, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x08, 0x53, 0x63, 0x65, 0x6e, 0x61, 0x72, 0x69, 0x6f, 0x12, 0x14, 0x0a, 0x05, 0x47,
-	0x72, 0x6f, 0x75, 0x70, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x47, 0x72, 0x6f, 0x75,
-	0x70, 0x12, 0x18, 0x0a, 0x07, 0x48, 0x54, 0x54, 0x50, 0x55, 0x72, 0x6c, 0x18, 0x07, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x07, 0x48, 0x54, 0x54, 0x50, 0x55, 0x72, 0x6c, 0x12, 0x1e, 0x0a, 0x0a, 0x48,
-	0x54, 0x54, 0x50, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x0a, 0x48, 0x54, 0x54, 0x50, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x48,
-	0x54, 0x54, 0x50, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52,
-	0x0a, 0x48, 0x54, 0x54, 0x50, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x42, 0x0f, 0x5a, 0x0d, 0x2e,
-	0x2f, 0x3b, 0x63, 0x72, 0x6f, 0x63, 0x6f, 0x73, 0x70, 0x61, 0x6e, 0x73, 0x62, 0x06, 0x70, 0x72,
-	0x6f, 0x74, 0x6f, 0x33,
+	0x28, 0x0a, 0x0f, 0x54, 0x65, 0x73, 0x74, 0x52, 0x75, 0x6e, 0x49, 0x44, 0x53, 0x74, 0x72, 0x69,
+	0x6e, 0x67, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x54, 0x65, 0x73, 0x74, 0x52, 0x75,
+	0x6e, 0x49, 0x44, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x12, 0x1a, 0x0a, 0x08, 0x53, 0x63, 0x65,
+	0x6e, 0x61, 0x72, 0x69, 0x6f, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x53, 0x63, 0x65,
+	0x6e, 0x61, 0x72, 0x69, 0x6f, 0x12, 0x14, 0x0a, 0x05, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x18, 0x0a, 0x07, 0x48,
+	0x54, 0x54, 0x50, 0x55, 0x72, 0x6c, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x48, 0x54,
+	0x54, 0x50, 0x55, 0x72, 0x6c, 0x12, 0x1e, 0x0a, 0x0a, 0x48, 0x54, 0x54, 0x50, 0x4d, 0x65, 0x74,
+	0x68, 0x6f, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x48, 0x54, 0x54, 0x50, 0x4d,
+	0x65, 0x74, 0x68, 0x6f, 0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x48, 0x54, 0x54, 0x50, 0x53, 0x74, 0x61,
+	0x74, 0x75, 0x73, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x48, 0x54, 0x54, 0x50, 0x53,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x53, 0x70, 0x61, 0x6e, 0x49, 0x44, 0x18,
+	0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x53, 0x70, 0x61, 0x6e, 0x49, 0x44, 0x12, 0x12, 0x0a,
+	0x04, 0x56, 0x55, 0x49, 0x44, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x56, 0x55, 0x49,
+	0x44, 0x12, 0x1c, 0x0a, 0x09, 0x49, 0x74, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0d,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x49, 0x74, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12,
+	0x2a, 0x0a, 0x10, 0x45, 0x78, 0x70, 0x65, 0x63, 0x74, 0x65, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x08, 0x52, 0x10, 0x45, 0x78, 0x70, 0x65, 0x63,
+	0x74, 0x65, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x45,
+	0x72, 0x72, 0x6f, 0x72, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x45, 0x72, 0x72, 0x6f,
+	0x72, 0x12, 0x1c, 0x0a, 0x09, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x43, 0x6f, 0x64, 0x65, 0x18, 0x10,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x43, 0x6f, 0x64, 0x65, 0x12,
+	0x14, 0x0a, 0x05, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x18, 0x11, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x50, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x1e, 0x0a, 0x0a, 0x54, 0x4c, 0x53, 0x56, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x18, 0x12, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x54, 0x4c, 0x53, 0x56, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x13, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x42, 0x79, 0x74, 0x65, 0x73, 0x18, 0x14, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x31, 0x0a,
+	0x04, 0x54, 0x61, 0x67, 0x73, 0x18, 0x15, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1d, 0x2e, 0x63, 0x72,
+	0x6f, 0x63, 0x6f, 0x73, 0x70, 0x61, 0x6e, 0x73, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x2e, 0x54, 0x61, 0x67, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x04, 0x54, 0x61, 0x67, 0x73,
+	0x12, 0x29, 0x0a, 0x06, 0x50, 0x68, 0x61, 0x73, 0x65, 0x73, 0x18, 0x16, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x11, 0x2e, 0x63, 0x72, 0x6f, 0x63, 0x6f, 0x73, 0x70, 0x61, 0x6e, 0x73, 0x2e, 0x50, 0x68,
+	0x61, 0x73, 0x65, 0x52, 0x06, 0x50, 0x68, 0x61, 0x73, 0x65, 0x73, 0x12, 0x26, 0x0a, 0x05, 0x4c,
+	0x69, 0x6e, 0x6b, 0x73, 0x18, 0x17, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x63, 0x72, 0x6f,
+	0x63, 0x6f, 0x73, 0x70, 0x61, 0x6e, 0x73, 0x2e, 0x4c, 0x69, 0x6e, 0x6b, 0x52, 0x05, 0x4c, 0x69,
+	0x6e, 0x6b, 0x73, 0x12, 0x22, 0x0a, 0x0c, 0x50, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x53, 0x70, 0x61,
+	0x6e, 0x49, 0x44, 0x18, 0x18, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x50, 0x61, 0x72, 0x65, 0x6e,
+	0x74, 0x53, 0x70, 0x61, 0x6e, 0x49, 0x44, 0x12, 0x24, 0x0a, 0x0d, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x18, 0x19, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0d,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x1a, 0x37, 0x0a,
+	0x09, 0x54, 0x61, 0x67, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65,
+	0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x73, 0x0a, 0x05, 0x50, 0x68, 0x61, 0x73, 0x65, 0x12,
+	0x12, 0x0a, 0x04, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x4e,
+	0x61, 0x6d, 0x65, 0x12, 0x2c, 0x0a, 0x11, 0x53, 0x74, 0x61, 0x72, 0x74, 0x54, 0x69, 0x6d, 0x65,
+	0x55, 0x6e, 0x69, 0x78, 0x4e, 0x61, 0x6e, 0x6f, 0x18, 0x02, 0x20, 0x01, 0x28, 0x06, 0x52, 0x11,
+	0x53, 0x74, 0x61, 0x72, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x55, 0x6e, 0x69, 0x78, 0x4e, 0x61, 0x6e,
+	0x6f, 0x12, 0x28, 0x0a, 0x0f, 0x45, 0x6e, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x55, 0x6e, 0x69, 0x78,
+	0x4e, 0x61, 0x6e, 0x6f, 0x18, 0x03, 0x20, 0x01, 0x28, 0x06, 0x52, 0x0f, 0x45, 0x6e, 0x64, 0x54,
+	0x69, 0x6d, 0x65, 0x55, 0x6e, 0x69, 0x78, 0x4e, 0x61, 0x6e, 0x6f, 0x22, 0x4c, 0x0a, 0x04, 0x4c,
+	0x69, 0x6e, 0x6b, 0x12, 0x18, 0x0a, 0x07, 0x54, 0x72, 0x61, 0x63, 0x65, 0x49, 0x44, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x54, 0x72, 0x61, 0x63, 0x65, 0x49, 0x44, 0x12, 0x16, 0x0a,
+	0x06, 0x53, 0x70, 0x61, 0x6e, 0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x53,
+	0x70, 0x61, 0x6e, 0x49, 0x44, 0x12, 0x12, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x54, 0x79, 0x70, 0x65, 0x42, 0x0f, 0x5a, 0x0d, 0x2e, 0x2f, 0x3b,
+	0x63, 0x72, 0x6f, 0x63, 0x6f, 0x73, 0x70, 0x61, 0x6e, 0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
 }
 
 var (
@@ -241,18 +548,24 @@ func file_crocospans_proto_rawDescGZIP() []byte {
 	return file_crocospans_proto_rawDescData
 }
 
-var file_crocospans_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_crocospans_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_crocospans_proto_goTypes = []interface{}{
 	(*RequestBatch)(nil), // 0: crocospans.RequestBatch
 	(*Request)(nil),      // 1: crocospans.Request
+	(*Phase)(nil),        // 2: crocospans.Phase
+	(*Link)(nil),         // 3: crocospans.Link
+	nil,                  // 4: crocospans.Request.TagsEntry
 }
 var file_crocospans_proto_depIdxs = []int32{
 	1, // 0: crocospans.RequestBatch.Requests:type_name -> crocospans.Request
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	4, // 1: crocospans.Request.Tags:type_name -> crocospans.Request.TagsEntry
+	2, // 2: crocospans.Request.Phases:type_name -> crocospans.Phase
+	3, // 3: crocospans.Request.Links:type_name -> crocospans.Link
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_crocospans_proto_init() }
@@ -285,6 +598,30 @@ func file_crocospans_proto_init() {
 				return nil
 			}
 		}
+		file_crocospans_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Phase); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_crocospans_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Link); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -292,7 +629,7 @@ func file_crocospans_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_crocospans_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
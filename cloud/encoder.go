@@ -0,0 +1,99 @@
+package crocospans
+
+import (
+	"unsafe"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// batchEncoder encodes a batch of request spans in the wire format of a
+// specific tracing backend. Depending on the format, the spans may have to be
+// split into multiple payloads, each of which is pushed separately.
+type batchEncoder interface {
+	ContentType() string
+	Encode(requests []*Request) ([][]byte, error)
+}
+
+// spanCounter is implemented by the encoders that split the spans into more
+// than one payload, to tell how many spans each payload has.
+type spanCounter interface {
+	spanCounts(requests []*Request) []int
+}
+
+// protoEncoder encodes spans as a crocospans RequestBatch protobuf message.
+type protoEncoder struct{}
+
+func (protoEncoder) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protoEncoder) Encode(requests []*Request) ([][]byte, error) {
+	md := &RequestBatch{
+		// TODO: FIXME: unsafe.Sizeof() here is almost certainly a bug and both
+		// Count and SizeBytes should be unnecessary
+		SizeBytes: int64(unsafe.Sizeof(requests)),
+		Count:     int64(len(requests)),
+		Requests:  requests,
+	}
+	batch, err := proto.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{batch}, nil
+}
+
+// ndjsonEncoder encodes spans as newline-delimited JSON, one Request per line.
+type ndjsonEncoder struct{}
+
+func (ndjsonEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (ndjsonEncoder) Encode(requests []*Request) ([][]byte, error) {
+	var batch []byte
+	for _, req := range requests {
+		line, err := protojson.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, line...)
+		batch = append(batch, '\n')
+	}
+	return [][]byte{batch}, nil
+}
+
+// delimitedProtoEncoder encodes spans as a stream of Request protobuf
+// messages, each one prefixed by its size as a varint.
+type delimitedProtoEncoder struct{}
+
+func (delimitedProtoEncoder) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (delimitedProtoEncoder) Encode(requests []*Request) ([][]byte, error) {
+	var batch []byte
+	for _, req := range requests {
+		msg, err := proto.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		batch = protowire.AppendVarint(batch, uint64(len(msg)))
+		batch = append(batch, msg...)
+	}
+	return [][]byte{batch}, nil
+}
+
+// lineEncoder terminates every payload of the wrapped encoder with a newline.
+type lineEncoder struct {
+	batchEncoder
+}
+
+func (e lineEncoder) Encode(requests []*Request) ([][]byte, error) {
+	batches, err := e.batchEncoder.Encode(requests)
+	for i := range batches {
+		batches[i] = append(batches[i], '\n')
+	}
+	return batches, err
+}
@@ -0,0 +1,302 @@
+package crocospans
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"go.k6.io/k6/output"
+)
+
+// Span file formats.
+const (
+	FileFormatNDJSON   = "ndjson"
+	FileFormatOTLPJSON = "otlp-json"
+	FileFormatProtobuf = "protobuf"
+)
+
+// FileConfig is the config for the file span output, on top of the common
+// Config options.
+type FileConfig struct {
+	// Path is the file the spans are written to, or "-" for stdout.
+	Path   string
+	Format string
+	Gzip   bool
+	// MaxSize is the size in bytes after which a new file is started. Zero
+	// means the file is never rotated.
+	MaxSize int64
+}
+
+var fileOutputSpec = outputSpec{
+	name:      "xk6-spans-file",
+	envPrefix: "XK6_SPANS_FILE_",
+	argOption: "path",
+	options:   []string{"path", "format", "gzip", "maxSize"},
+}
+
+// newFileConfig parses the file output options from the config layers.
+func newFileConfig(layers *configLayers) (FileConfig, error) {
+	cfg := FileConfig{Format: FileFormatNDJSON}
+
+	layers.string("path", &cfg.Path)
+	if cfg.Path == "" {
+		return cfg, fmt.Errorf("missing spans file path, use '--out xk6-spans-file=spans.ndjson' or the %sPATH env var",
+			fileOutputSpec.envPrefix)
+	}
+	cfg.Gzip = strings.HasSuffix(cfg.Path, ".gz")
+
+	layers.string("format", &cfg.Format)
+	switch cfg.Format {
+	case FileFormatNDJSON, FileFormatOTLPJSON, FileFormatProtobuf:
+	default:
+		return cfg, layers.validationError("format", "should be %s, %s or %s but was '%s'",
+			FileFormatNDJSON, FileFormatOTLPJSON, FileFormatProtobuf, cfg.Format)
+	}
+
+	if err := layers.bool("gzip", &cfg.Gzip); err != nil {
+		return cfg, err
+	}
+
+	if err := layers.int64("maxSize", &cfg.MaxSize); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxSize < 0 {
+		return cfg, layers.validationError("maxSize", "should not be negative but was %d", cfg.MaxSize)
+	}
+	if cfg.MaxSize > 0 && cfg.Path == "-" {
+		return cfg, layers.validationError("maxSize", "can't be used when writing to stdout")
+	}
+
+	return cfg, nil
+}
+
+// NewFile creates an output that writes the traced requests to a local file,
+// so they can be archived and replayed into a tracing backend later.
+func NewFile(p output.Params) (*Output, error) {
+	conf, layers, err := newConfig(p, fileOutputSpec)
+	if err != nil {
+		return nil, err
+	}
+	fileConf, err := newFileConfig(layers)
+	if err != nil {
+		return nil, err
+	}
+	// Writes to the file have to be sequential.
+	conf.PushConcurrency = 1
+
+	var encoder batchEncoder
+	switch fileConf.Format {
+	case FileFormatNDJSON:
+		encoder = ndjsonEncoder{}
+	case FileFormatOTLPJSON:
+		encoder = lineEncoder{otlpJSONEncoder{}}
+	case FileFormatProtobuf:
+		encoder = delimitedProtoEncoder{}
+	}
+
+	writer := &spansFileWriter{config: fileConf, fs: p.FS, stdout: p.StdOut}
+	o, err := newOutput(p, fileOutputSpec.name, conf, encoder)
+	if err != nil {
+		return nil, err
+	}
+	o.send = writer.write
+	o.closer = writer
+	return o, nil
+}
+
+// spansFileWriter writes span batches to a file, optionally gzipped, and
+// rotates it when it grows over the configured size.
+type spansFileWriter struct {
+	config FileConfig
+	fs     afero.Fs
+	stdout io.Writer
+
+	file    io.WriteCloser
+	gzip    *gzip.Writer
+	written int64
+	index   int
+}
+
+// rotatedPath returns the path of the n-th file, e.g. spans.2.ndjson.gz for
+// spans.ndjson.gz. The first file is written to the configured path as is.
+func rotatedPath(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	dir, base := filepath.Split(path)
+	name, ext := base, ""
+	if i := strings.Index(base, "."); i > 0 {
+		name, ext = base[:i], base[i:]
+	}
+	return fmt.Sprintf("%s%s.%d%s", dir, name, n, ext)
+}
+
+func (w *spansFileWriter) open() error {
+	if w.config.Path == "-" {
+		w.file = nopCloser{w.stdout}
+	} else {
+		f, err := w.fs.Create(rotatedPath(w.config.Path, w.index))
+		if err != nil {
+			return err
+		}
+		w.file = f
+	}
+	w.written = 0
+	if w.config.Gzip {
+		w.gzip = gzip.NewWriter(countingWriter{w.file, &w.written})
+	}
+	return nil
+}
+
+func (w *spansFileWriter) write(batch []byte) error {
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	if w.gzip != nil {
+		if _, err := w.gzip.Write(batch); err != nil {
+			return err
+		}
+		// Flush every batch, so the file is readable while the test runs and
+		// its size is accurate for the rotation.
+		if err := w.gzip.Flush(); err != nil {
+			return err
+		}
+	} else {
+		n, err := w.file.Write(batch)
+		w.written += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.config.MaxSize > 0 && w.written >= w.config.MaxSize {
+		w.index++
+		return w.Close()
+	}
+	return nil
+}
+
+// Close finalizes the current file, if there is one. The next write opens a
+// new one.
+func (w *spansFileWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	file := w.file
+	w.file = nil
+	if w.gzip != nil {
+		if err := w.gzip.Close(); err != nil {
+			_ = file.Close()
+			return err
+		}
+		w.gzip = nil
+	}
+	return file.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	*cw.n += int64(n)
+	return n, err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// ReadSpansFile reads back the requests from a file written by the file span
+// output in the given format. Gzipped files are detected automatically.
+func ReadSpansFile(r io.Reader, format string) ([]*Request, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = gz.Close() }()
+		br = bufio.NewReader(gz)
+	}
+
+	switch format {
+	case FileFormatNDJSON, FileFormatOTLPJSON:
+		return readJSONLines(br, format)
+	case FileFormatProtobuf:
+		return readDelimitedProto(br)
+	default:
+		return nil, fmt.Errorf("unknown spans file format '%s'", format)
+	}
+}
+
+func readJSONLines(r *bufio.Reader, format string) ([]*Request, error) {
+	var requests []*Request
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if format == FileFormatNDJSON {
+			req := &Request{}
+			if err := protojson.Unmarshal(line, req); err != nil {
+				return nil, err
+			}
+			requests = append(requests, req)
+			continue
+		}
+
+		var data otlpTraceData
+		if err := json.Unmarshal(line, &data); err != nil {
+			return nil, err
+		}
+		reqs, err := requestsFromOTLP(data)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, reqs...)
+	}
+	return requests, scanner.Err()
+}
+
+func readDelimitedProto(r *bufio.Reader) ([]*Request, error) {
+	var requests []*Request
+	for {
+		size, err := binary.ReadUvarint(r)
+		if errors.Is(err, io.EOF) {
+			return requests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return nil, err
+		}
+		req := &Request{}
+		if err := proto.Unmarshal(msg, req); err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+}
@@ -0,0 +1,167 @@
+package crocospans
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"go.k6.io/k6/lib/testutils"
+	"go.k6.io/k6/metrics"
+	"go.k6.io/k6/output"
+)
+
+func writeTestSpansFile(t *testing.T, params output.Params, batches int) *Output {
+	t.Helper()
+
+	params.Logger = testutils.NewLogger(t)
+	if params.Environment == nil {
+		params.Environment = map[string]string{}
+	}
+	params.Environment["XK6_SPANS_FILE_PUSH_INTERVAL"] = "1h"
+	o, err := NewFile(params)
+	require.NoError(t, err)
+	require.NoError(t, o.Start())
+	for i := 0; i < batches; i++ {
+		o.AddMetricSamples([]metrics.SampleContainer{newTestTrail("abcdef")})
+		o.flushMetrics()
+	}
+	require.NoError(t, o.Stop())
+	return o
+}
+
+func TestFileOutputNDJSONWithRotation(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	writeTestSpansFile(t, output.Params{
+		ConfigArgument: "spans.ndjson",
+		FS:             fs,
+		Environment:    map[string]string{"XK6_SPANS_FILE_MAX_SIZE": "1"},
+	}, 3)
+
+	for _, path := range []string{"spans.ndjson", "spans.1.ndjson", "spans.2.ndjson"} {
+		data, err := afero.ReadFile(fs, path)
+		require.NoError(t, err, path)
+		req := &Request{}
+		require.NoError(t, protojson.Unmarshal(bytes.TrimSpace(data), req))
+		assert.Equal(t, "abcdef", req.TraceID)
+	}
+	exists, err := afero.Exists(fs, "spans.3.ndjson")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestFileOutputGzippedProtobuf(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	writeTestSpansFile(t, output.Params{
+		ConfigArgument: "spans.pb.gz",
+		FS:             fs,
+		Environment:    map[string]string{"XK6_SPANS_FILE_FORMAT": FileFormatProtobuf},
+	}, 2)
+
+	f, err := fs.Open("spans.pb.gz")
+	require.NoError(t, err)
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+
+	var count int
+	for len(data) > 0 {
+		size, n := protowire.ConsumeVarint(data)
+		require.Positive(t, n)
+		req := &Request{}
+		require.NoError(t, proto.Unmarshal(data[n:n+int(size)], req))
+		assert.Equal(t, "abcdef", req.TraceID)
+		data = data[n+int(size):]
+		count++
+	}
+	assert.Equal(t, 2, count)
+}
+
+func TestFileOutputOTLPJSONToStdout(t *testing.T) {
+	t.Parallel()
+
+	stdout := &bytes.Buffer{}
+	writeTestSpansFile(t, output.Params{
+		ConfigArgument: "-",
+		StdOut:         stdout,
+		Environment:    map[string]string{"XK6_SPANS_FILE_FORMAT": FileFormatOTLPJSON},
+	}, 2)
+
+	scanner := bufio.NewScanner(stdout)
+	var lines int
+	for scanner.Scan() {
+		var data otlpTraceData
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &data))
+		require.Len(t, data.ResourceSpans, 1)
+		spans := data.ResourceSpans[0].ScopeSpans[0].Spans
+		require.Len(t, spans, 1)
+		assert.Equal(t, "abcdef", spans[0].TraceID)
+		assert.Equal(t, otlpSpanKindClient, spans[0].Kind)
+		lines++
+	}
+	assert.Equal(t, 2, lines)
+}
+
+func TestRotatedPath(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "out/spans.ndjson.gz", rotatedPath("out/spans.ndjson.gz", 0))
+	assert.Equal(t, "out/spans.3.ndjson.gz", rotatedPath("out/spans.ndjson.gz", 3))
+	assert.Equal(t, "spans.1", rotatedPath("spans", 1))
+}
+
+func TestReadSpansFileRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{FileFormatNDJSON, FileFormatOTLPJSON, FileFormatProtobuf} {
+		format := format
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+
+			fs := afero.NewMemMapFs()
+			writeTestSpansFile(t, output.Params{
+				ConfigArgument: "spans.gz",
+				FS:             fs,
+				Environment: map[string]string{
+					"XK6_SPANS_FILE_FORMAT":      format,
+					"XK6_SPANS_FILE_PHASE_SPANS": "true",
+				},
+			}, 2)
+
+			f, err := fs.Open("spans.gz")
+			require.NoError(t, err)
+			requests, err := ReadSpansFile(f, format)
+			require.NoError(t, err)
+			require.Len(t, requests, 2)
+
+			trail := newTestTrail("abcdef")
+			expected, err := newRequest(trail, requests[0].TestRunIDString)
+			require.NoError(t, err)
+			expected.Phases = newPhases(trail)
+			for _, req := range requests {
+				assert.Equal(t, expected.TraceID, req.TraceID)
+				assert.Len(t, req.SpanID, 16)
+				assert.Equal(t, expected.Scenario, req.Scenario)
+				assert.Equal(t, expected.HTTPUrl, req.HTTPUrl)
+				assert.Equal(t, expected.HTTPStatus, req.HTTPStatus)
+				assert.Equal(t, expected.ExpectedResponse, req.ExpectedResponse)
+				require.Len(t, req.Phases, len(expected.Phases))
+				assert.Equal(t, expected.Phases[0].Name, req.Phases[0].Name)
+			}
+		})
+	}
+}
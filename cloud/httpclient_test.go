@@ -0,0 +1,116 @@
+package crocospans
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeClientCert generates a self-signed client certificate and writes it and
+// its key to PEM files in dir.
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "k6"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return cert, certFile, keyFile
+}
+
+func TestHTTPClientWithPrivateCAAndClientCert(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := writeClientCert(t, dir)
+
+	var gotHeader, gotToken string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("X-Gateway")
+		gotToken = r.Header.Get("X-Token")
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MinVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+
+	o := newTestOutput(t, srv.URL, map[string]string{
+		"XK6_CROCOSPANS_CA_FILE":   caFile,
+		"XK6_CROCOSPANS_CERT_FILE": certFile,
+		"XK6_CROCOSPANS_KEY_FILE":  keyFile,
+		"XK6_CROCOSPANS_HEADERS":   "X-Gateway=k6%20tests,X-Token=a+b/c==",
+	})
+	require.NoError(t, o.push([]byte("batch")))
+	assert.Equal(t, "k6 tests", gotHeader)
+	assert.Equal(t, "a+b/c==", gotToken)
+
+	// Without the client certificate, the server should refuse the connection.
+	o = newTestOutput(t, srv.URL, map[string]string{"XK6_CROCOSPANS_CA_FILE": caFile})
+	assert.Error(t, o.push([]byte("batch")))
+
+	// And without the private CA, the server certificate can't be verified,
+	// unless the verification is explicitly skipped.
+	o = newTestOutput(t, srv.URL, nil)
+	assert.Error(t, o.push([]byte("batch")))
+	o = newTestOutput(t, srv.URL, map[string]string{
+		"XK6_CROCOSPANS_INSECURE_SKIP_VERIFY": "true",
+		"XK6_CROCOSPANS_CERT_FILE":            certFile,
+		"XK6_CROCOSPANS_KEY_FILE":             keyFile,
+	})
+	assert.NoError(t, o.push([]byte("batch")))
+}
+
+func TestHTTPClientProxyAndTimeout(t *testing.T) {
+	t.Parallel()
+
+	var proxied bool
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.Host == "ingest.invalid"
+	}))
+	defer proxy.Close()
+
+	o := newTestOutput(t, "http://ingest.invalid/push", map[string]string{
+		"XK6_CROCOSPANS_PROXY_URL": proxy.URL,
+		"XK6_CROCOSPANS_TIMEOUT":   "3s",
+	})
+	assert.Equal(t, 3*time.Second, o.httpClient.Timeout)
+	require.NoError(t, o.push([]byte("batch")))
+	assert.True(t, proxied)
+}
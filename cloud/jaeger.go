@@ -0,0 +1,294 @@
+package crocospans
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"go.k6.io/k6/output"
+)
+
+const jaegerTracesPath = "/api/traces"
+
+// NewJaeger creates an output that sends the traced requests directly to a
+// Jaeger collector, as Jaeger Thrift batches over HTTP.
+func NewJaeger(p output.Params) (*Output, error) {
+	conf, _, err := newConfig(p, outputSpec{name: "xk6-jaeger", envPrefix: "XK6_JAEGER_"})
+	if err != nil {
+		return nil, err
+	}
+
+	// Allow just the collector address to be specified, e.g. http://localhost:14268
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = jaegerTracesPath
+		conf.Endpoint = u.String()
+	}
+
+	return newOutput(p, "xk6-jaeger", conf, jaegerEncoder{})
+}
+
+// Thrift binary protocol type IDs.
+const (
+	thriftStop   = 0
+	thriftBool   = 2
+	thriftI32    = 8
+	thriftI64    = 10
+	thriftString = 11
+	thriftStruct = 12
+	thriftList   = 15
+)
+
+// Jaeger Thrift enum values, see https://github.com/jaegertracing/jaeger-idl/blob/main/thrift/jaeger.thrift
+const (
+	jaegerTagString = 0
+	jaegerTagBool   = 2
+	jaegerTagLong   = 3
+
+	jaegerRefFollowsFrom = 1
+)
+
+// jaegerEncoder encodes spans as Jaeger Thrift batches, using the binary
+// protocol expected by the collector's /api/traces endpoint. Since the k6
+// process is described once per batch, a separate batch is made per scenario.
+type jaegerEncoder struct{}
+
+func (jaegerEncoder) ContentType() string {
+	return "application/x-thrift"
+}
+
+// groupByScenario splits the requests by scenario, in the order of the
+// scenario names, since each Jaeger batch has a single process.
+func groupByScenario(requests []*Request) [][]*Request {
+	byScenario := make(map[string][]*Request)
+	for _, req := range requests {
+		byScenario[req.Scenario] = append(byScenario[req.Scenario], req)
+	}
+	scenarios := make([]string, 0, len(byScenario))
+	for scenario := range byScenario {
+		scenarios = append(scenarios, scenario)
+	}
+	sort.Strings(scenarios)
+
+	groups := make([][]*Request, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		groups = append(groups, byScenario[scenario])
+	}
+	return groups
+}
+
+func (jaegerEncoder) spanCounts(requests []*Request) []int {
+	groups := groupByScenario(requests)
+	counts := make([]int, len(groups))
+	for i, reqs := range groups {
+		counts[i] = len(reqs)
+	}
+	return counts
+}
+
+func (jaegerEncoder) Encode(requests []*Request) ([][]byte, error) {
+	groups := groupByScenario(requests)
+	batches := make([][]byte, 0, len(groups))
+	for _, reqs := range groups {
+		scenario := reqs[0].Scenario
+		w := &thriftWriter{}
+
+		// Batch.process
+		w.fieldHeader(thriftStruct, 1)
+		w.fieldHeader(thriftString, 1)
+		w.string("k6")
+		w.fieldHeader(thriftList, 2)
+		processTags := []jaegerTag{{key: "k6.test_run_id", str: reqs[0].TestRunIDString}}
+		if scenario != "" {
+			processTags = append(processTags, jaegerTag{key: "k6.scenario", str: scenario})
+		}
+		w.tags(processTags)
+		w.stop()
+
+		// Batch.spans
+		w.fieldHeader(thriftList, 2)
+		w.listHeader(thriftStruct, len(reqs))
+		for _, req := range reqs {
+			w.span(req)
+		}
+		w.stop()
+
+		batches = append(batches, w.buf.Bytes())
+	}
+	return batches, nil
+}
+
+type jaegerTag struct {
+	key   string
+	vType int32
+	str   string
+	long  int64
+	bool  bool
+}
+
+// thriftWriter writes Thrift structs with the binary protocol.
+type thriftWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *thriftWriter) fieldHeader(typ byte, id int16) {
+	w.buf.WriteByte(typ)
+	_ = binary.Write(&w.buf, binary.BigEndian, id)
+}
+
+func (w *thriftWriter) stop() {
+	w.buf.WriteByte(thriftStop)
+}
+
+func (w *thriftWriter) listHeader(elemType byte, size int) {
+	w.buf.WriteByte(elemType)
+	w.i32(int32(size))
+}
+
+func (w *thriftWriter) i32(v int32) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *thriftWriter) i64(v int64) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *thriftWriter) string(v string) {
+	w.i32(int32(len(v)))
+	w.buf.WriteString(v)
+}
+
+func (w *thriftWriter) bool(v bool) {
+	if v {
+		w.buf.WriteByte(1)
+	} else {
+		w.buf.WriteByte(0)
+	}
+}
+
+func (w *thriftWriter) tags(tags []jaegerTag) {
+	w.listHeader(thriftStruct, len(tags))
+	for _, tag := range tags {
+		w.fieldHeader(thriftString, 1)
+		w.string(tag.key)
+		w.fieldHeader(thriftI32, 2)
+		w.i32(tag.vType)
+		switch tag.vType {
+		case jaegerTagString:
+			w.fieldHeader(thriftString, 3)
+			w.string(tag.str)
+		case jaegerTagBool:
+			w.fieldHeader(thriftBool, 5)
+			w.bool(tag.bool)
+		case jaegerTagLong:
+			w.fieldHeader(thriftI64, 6)
+			w.i64(tag.long)
+		}
+		w.stop()
+	}
+}
+
+func (w *thriftWriter) span(req *Request) {
+	traceIDHigh, traceIDLow := jaegerTraceID(req.TraceID)
+
+	w.fieldHeader(thriftI64, 1)
+	w.i64(traceIDLow)
+	w.fieldHeader(thriftI64, 2)
+	w.i64(traceIDHigh)
+	w.fieldHeader(thriftI64, 3)
+	w.i64(jaegerID(req.SpanID))
+	w.fieldHeader(thriftI64, 4)
+	w.i64(jaegerID(req.ParentSpanID)) // zero for the root spans of k6 requests
+	w.fieldHeader(thriftString, 5)
+	if req.Name != "" {
+		w.string(req.HTTPMethod + " " + req.Name)
+	} else {
+		w.string(req.HTTPMethod)
+	}
+
+	if len(req.Links) > 0 {
+		w.fieldHeader(thriftList, 6)
+		w.listHeader(thriftStruct, len(req.Links))
+		for _, link := range req.Links {
+			high, low := jaegerTraceID(link.TraceID)
+			w.fieldHeader(thriftI32, 1)
+			w.i32(jaegerRefFollowsFrom)
+			w.fieldHeader(thriftI64, 2)
+			w.i64(low)
+			w.fieldHeader(thriftI64, 3)
+			w.i64(high)
+			w.fieldHeader(thriftI64, 4)
+			w.i64(jaegerID(link.SpanID))
+			w.stop()
+		}
+	}
+
+	w.fieldHeader(thriftI32, 7)
+	w.i32(1) // sampled, same as the flags in the uber-trace-id header
+	w.fieldHeader(thriftI64, 8)
+	w.i64(int64(req.StartTimeUnixNano / 1000))
+	w.fieldHeader(thriftI64, 9)
+	w.i64(int64((req.EndTimeUnixNano - req.StartTimeUnixNano) / 1000))
+
+	tags := []jaegerTag{
+		{key: "span.kind", str: "client"},
+		{key: "http.method", str: req.HTTPMethod},
+		{key: "http.url", str: req.HTTPUrl},
+		{key: "http.status_code", vType: jaegerTagLong, long: req.HTTPStatus},
+		{key: "k6.vu", vType: jaegerTagLong, long: req.VUID},
+		{key: "k6.iteration", vType: jaegerTagLong, long: req.Iteration},
+	}
+	if req.Group != "" {
+		tags = append(tags, jaegerTag{key: "k6.group", str: req.Group})
+	}
+	if req.Error != "" || !req.ExpectedResponse {
+		tags = append(tags, jaegerTag{key: "error", vType: jaegerTagBool, bool: true})
+	}
+	userTags := make([]string, 0, len(req.Tags))
+	for k := range req.Tags {
+		userTags = append(userTags, k)
+	}
+	sort.Strings(userTags)
+	for _, k := range userTags {
+		tags = append(tags, jaegerTag{key: k, str: req.Tags[k]})
+	}
+	w.fieldHeader(thriftList, 10)
+	w.tags(tags)
+
+	if len(req.Phases) > 0 {
+		w.fieldHeader(thriftList, 11)
+		w.listHeader(thriftStruct, len(req.Phases))
+		for _, phase := range req.Phases {
+			w.fieldHeader(thriftI64, 1)
+			w.i64(int64(phase.StartTimeUnixNano / 1000))
+			w.fieldHeader(thriftList, 2)
+			w.tags([]jaegerTag{
+				{key: "event", str: phase.Name},
+				{key: "duration_us", vType: jaegerTagLong, long: int64((phase.EndTimeUnixNano - phase.StartTimeUnixNano) / 1000)},
+			})
+			w.stop()
+		}
+	}
+
+	w.stop()
+}
+
+// jaegerTraceID splits a hex trace ID, as generated by WithTrace, into the
+// high and low 64 bits Jaeger uses.
+func jaegerTraceID(traceID string) (high, low int64) {
+	if len(traceID) > 16 {
+		return jaegerID(traceID[:len(traceID)-16]), jaegerID(traceID[len(traceID)-16:])
+	}
+	return 0, jaegerID(traceID)
+}
+
+// jaegerID parses a hex ID of up to 64 bits, returning 0 if it's invalid.
+func jaegerID(id string) int64 {
+	v, _ := strconv.ParseUint(id, 16, 64)
+	return int64(v)
+}
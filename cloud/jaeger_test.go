@@ -0,0 +1,241 @@
+package crocospans
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJaegerTraceID(t *testing.T) {
+	t.Parallel()
+
+	expHigh, expLow := uint64(0xdc071880c0e3d3c5), uint64(0xca869c2d736f6d65)
+	high, low := jaegerTraceID("dc071880c0e3d3c5ca869c2d736f6d65")
+	assert.Equal(t, int64(expHigh), high)
+	assert.Equal(t, int64(expLow), low)
+
+	high, low = jaegerTraceID("736f6d65")
+	assert.Equal(t, int64(0), high)
+	assert.Equal(t, int64(0x736f6d65), low)
+}
+
+func TestJaegerEncoderSplitsBatchesByScenario(t *testing.T) {
+	t.Parallel()
+
+	batches, err := jaegerEncoder{}.Encode([]*Request{
+		{TraceID: "abcdef", SpanID: "123456", Scenario: "b", TestRunIDString: "run"},
+		{TraceID: "abcdef", SpanID: "123457", Scenario: "a", TestRunIDString: "run"},
+		{TraceID: "abcdef", SpanID: "123458", Scenario: "b", TestRunIDString: "run"},
+	})
+	require.NoError(t, err)
+	require.Len(t, batches, 2)
+
+	// Batch.process is a struct with ID 1, followed by Process.serviceName
+	// as a string field with ID 1.
+	processPrefix := []byte{thriftStruct, 0, 1, thriftString, 0, 1, 0, 0, 0, 2, 'k', '6'}
+	for i, scenario := range []string{"a", "b"} {
+		assert.True(t, bytes.HasPrefix(batches[i], processPrefix))
+
+		scenarioTag := append([]byte("k6.scenario"), thriftI32, 0, 2, 0, 0, 0, jaegerTagString, thriftString, 0, 3, 0, 0, 0, 1)
+		assert.True(t, bytes.Contains(batches[i], append(scenarioTag, scenario...)))
+	}
+
+	spansHeader := func(count byte) []byte {
+		return []byte{thriftList, 0, 2, thriftStruct, 0, 0, 0, count}
+	}
+	assert.True(t, bytes.Contains(batches[0], spansHeader(1)))
+	assert.True(t, bytes.Contains(batches[1], spansHeader(2)))
+}
+
+func TestJaegerEncoderSpanFields(t *testing.T) {
+	t.Parallel()
+
+	batches, err := jaegerEncoder{}.Encode([]*Request{{
+		StartTimeUnixNano: 1_500_000,
+		EndTimeUnixNano:   4_500_000,
+		TraceID:           "f1e2d3c4b5a697880a1b2c3d4e5f6071",
+		SpanID:            "8899aabbccddeeff",
+		ParentSpanID:      "0011223344556677",
+		TestRunIDString:   "run",
+		Scenario:          "checkout",
+		Group:             "::pay",
+		HTTPMethod:        "POST",
+		HTTPUrl:           "http://shop/pay",
+		HTTPStatus:        502,
+		VUID:              3,
+		Iteration:         7,
+		Name:              "http://shop/pay",
+		Tags:              map[string]string{"env": "staging"},
+		Phases:            []*Phase{{Name: "waiting", StartTimeUnixNano: 2_000_000, EndTimeUnixNano: 4_000_000}},
+		Links:             []*Link{{TraceID: "ffeeddccbbaa99887766554433221100", SpanID: "fedcba9876543210"}},
+	}})
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+
+	batch := decodeThriftStruct(t, batches[0])
+	process := batch[1].(thriftStructValue)
+	assert.Equal(t, "k6", process[1])
+	assert.Equal(t, map[string]interface{}{"k6.test_run_id": "run", "k6.scenario": "checkout"}, jaegerTagValues(t, process[2]))
+
+	spans := batch[2].([]interface{})
+	require.Len(t, spans, 1)
+	span := spans[0].(thriftStructValue)
+	hex := func(field interface{}) string {
+		return fmt.Sprintf("%016x", uint64(field.(int64)))
+	}
+	assert.Equal(t, "0a1b2c3d4e5f6071", hex(span[1]), "traceIdLow")
+	assert.Equal(t, "f1e2d3c4b5a69788", hex(span[2]), "traceIdHigh")
+	assert.Equal(t, "8899aabbccddeeff", hex(span[3]), "spanId")
+	assert.Equal(t, "0011223344556677", hex(span[4]), "parentSpanId")
+	assert.Equal(t, "POST http://shop/pay", span[5], "operationName")
+	assert.Equal(t, int32(1), span[7], "flags")
+	assert.Equal(t, int64(1500), span[8], "startTime")
+	assert.Equal(t, int64(3000), span[9], "duration")
+
+	references := span[6].([]interface{})
+	require.Len(t, references, 1)
+	reference := references[0].(thriftStructValue)
+	assert.Equal(t, int32(1), reference[1], "refType is FOLLOWS_FROM")
+	assert.Equal(t, "7766554433221100", hex(reference[2]), "traceIdLow")
+	assert.Equal(t, "ffeeddccbbaa9988", hex(reference[3]), "traceIdHigh")
+	assert.Equal(t, "fedcba9876543210", hex(reference[4]), "spanId")
+
+	assert.Equal(t, map[string]interface{}{
+		"span.kind":        "client",
+		"http.method":      "POST",
+		"http.url":         "http://shop/pay",
+		"http.status_code": int64(502),
+		"k6.vu":            int64(3),
+		"k6.iteration":     int64(7),
+		"k6.group":         "::pay",
+		"error":            true,
+		"env":              "staging",
+	}, jaegerTagValues(t, span[10]))
+
+	logs := span[11].([]interface{})
+	require.Len(t, logs, 1)
+	log := logs[0].(thriftStructValue)
+	assert.Equal(t, int64(2000), log[1], "timestamp")
+	assert.Equal(t, map[string]interface{}{"event": "waiting", "duration_us": int64(2000)}, jaegerTagValues(t, log[2]))
+}
+
+// thriftStructValue is a struct decoded by decodeThriftStruct, by field ID.
+type thriftStructValue map[int16]interface{}
+
+// decodeThriftStruct decodes a struct encoded with the Thrift binary protocol,
+// following the protocol spec rather than the encoder, and fails the test if
+// there are bytes left after it.
+func decodeThriftStruct(t *testing.T, data []byte) thriftStructValue {
+	t.Helper()
+
+	r := bytes.NewReader(data)
+	var readValue func(typ byte) interface{}
+	read := func(v interface{}) {
+		require.NoError(t, binary.Read(r, binary.BigEndian, v))
+	}
+	readValue = func(typ byte) interface{} {
+		switch typ {
+		case 2: // bool
+			var v byte
+			read(&v)
+			return v != 0
+		case 3: // byte
+			var v int8
+			read(&v)
+			return v
+		case 4: // double
+			var v float64
+			read(&v)
+			return v
+		case 6: // i16
+			var v int16
+			read(&v)
+			return v
+		case 8: // i32
+			var v int32
+			read(&v)
+			return v
+		case 10: // i64
+			var v int64
+			read(&v)
+			return v
+		case 11: // string or binary
+			var size int32
+			read(&size)
+			require.GreaterOrEqual(t, size, int32(0))
+			v := make([]byte, size)
+			read(v)
+			return string(v)
+		case 12: // struct
+			s := make(thriftStructValue)
+			for {
+				var fieldType byte
+				read(&fieldType)
+				if fieldType == 0 {
+					return s
+				}
+				var id int16
+				read(&id)
+				require.NotContains(t, s, id, "duplicate field")
+				s[id] = readValue(fieldType)
+			}
+		case 13: // map
+			var keyType, valType byte
+			var size int32
+			read(&keyType)
+			read(&valType)
+			read(&size)
+			m := make(map[interface{}]interface{}, size)
+			for i := int32(0); i < size; i++ {
+				m[readValue(keyType)] = readValue(valType)
+			}
+			return m
+		case 14, 15: // set or list
+			var elemType byte
+			var size int32
+			read(&elemType)
+			read(&size)
+			l := make([]interface{}, size)
+			for i := range l {
+				l[i] = readValue(elemType)
+			}
+			return l
+		default:
+			require.FailNow(t, "unknown thrift type", "%d", typ)
+			return nil
+		}
+	}
+
+	s := readValue(12).(thriftStructValue)
+	require.Zero(t, r.Len(), "trailing bytes")
+	return s
+}
+
+// jaegerTagValues returns the values of a decoded list of Jaeger tags by key,
+// as strings, bools or int64s depending on their vType.
+func jaegerTagValues(t *testing.T, list interface{}) map[string]interface{} {
+	t.Helper()
+
+	values := make(map[string]interface{})
+	for _, elem := range list.([]interface{}) {
+		tag := elem.(thriftStructValue)
+		key := tag[1].(string)
+		require.NotContains(t, values, key, "duplicate tag")
+		switch vType := tag[2].(int32); vType {
+		case 0: // STRING
+			values[key] = tag[3]
+		case 2: // BOOL
+			values[key] = tag[5]
+		case 3: // LONG
+			values[key] = tag[6]
+		default:
+			require.FailNow(t, "unexpected tag type", "%s: %d", key, vType)
+		}
+		require.NotNil(t, values[key], key)
+	}
+	return values
+}
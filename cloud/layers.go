@@ -0,0 +1,315 @@
+package crocospans
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.k6.io/k6/output"
+)
+
+// outputSpec describes how the config of a span output is named.
+type outputSpec struct {
+	// name is the output name, as used with --out.
+	name string
+	// envPrefix is the prefix of all environment variables of the output.
+	envPrefix string
+	// argOption is the option set by the --out argument, without its query.
+	argOption string
+	// options are the output-specific options, on top of the common ones.
+	options []string
+	// authDefaults optionally fills in the auth options that weren't set
+	// explicitly, before they are validated.
+	authDefaults func(cfg *Config, layers *configLayers, env map[string]string) error
+}
+
+// configLayers holds the option values of an output from all config sources,
+// layered in the standard k6 order: the JSON config file, then the environment
+// variables and finally the --out argument, with its query parameters like
+// '?pushInterval=5s&orgID=1'. It keeps track of where each value came from, so
+// errors can point to it.
+type configLayers struct {
+	values  map[string]string
+	sources map[string]string
+}
+
+func newConfigLayers(params output.Params, spec outputSpec, options []string) (*configLayers, error) {
+	layers := &configLayers{
+		values:  make(map[string]string),
+		sources: make(map[string]string),
+	}
+	known := make(map[string]bool, len(options))
+	for _, name := range options {
+		known[name] = true
+	}
+
+	if len(bytes.TrimSpace(params.JSONConfig)) > 0 {
+		// The numbers are kept as they were written, since large integers,
+		// like a max file size, would be formatted in exponent notation as
+		// floats.
+		var jsonConf map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(params.JSONConfig))
+		dec.UseNumber()
+		if err := dec.Decode(&jsonConf); err != nil {
+			return nil, fmt.Errorf("error parsing the %s JSON config: %w", spec.name, err)
+		}
+		for name, val := range jsonConf {
+			if !known[name] {
+				return nil, fmt.Errorf("unknown option '%s' in the %s JSON config", name, spec.name)
+			}
+			switch v := val.(type) {
+			case nil:
+				continue
+			case string:
+				layers.set(name, v, "the JSON config")
+			case json.Number:
+				layers.set(name, v.String(), "the JSON config")
+			case bool:
+				layers.set(name, strconv.FormatBool(v), "the JSON config")
+			case map[string]interface{}:
+				if !objectOptions[name] {
+					return nil, fmt.Errorf("invalid value of option '%s' in the %s JSON config", name, spec.name)
+				}
+				pairs, err := jsonObjectPairs(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value of option '%s' in the %s JSON config: %w", name, spec.name, err)
+				}
+				layers.set(name, pairs, "the JSON config")
+			default:
+				return nil, fmt.Errorf("invalid value of option '%s' in the %s JSON config", name, spec.name)
+			}
+		}
+	}
+
+	for _, name := range options {
+		envName := spec.envPrefix + envOptionName(name)
+		if val, ok := params.Environment[envName]; ok {
+			layers.set(name, val, fmt.Sprintf("environment variable '%s'", envName))
+		}
+	}
+
+	if arg := params.ConfigArgument; arg != "" {
+		source := fmt.Sprintf("the '--out %s' argument", spec.name)
+		if i := strings.IndexByte(arg, '?'); i >= 0 {
+			query, err := url.ParseQuery(arg[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing the query of %s: %w", source, err)
+			}
+			// Only the known options are taken out of the query, so the
+			// endpoint can have its own query parameters.
+			for name, vals := range query {
+				if known[name] && name != spec.argOption {
+					layers.set(name, vals[len(vals)-1], source)
+					query.Del(name)
+				}
+			}
+			arg = arg[:i]
+			if len(query) > 0 {
+				arg += "?" + query.Encode()
+			}
+		}
+		layers.set(spec.argOption, arg, source)
+	}
+
+	return layers, nil
+}
+
+// objectOptions are the options that can be set with an object in the JSON
+// config, besides the 'key1=value1,key2=value2' format of the other sources.
+var objectOptions = map[string]bool{"headers": true}
+
+// jsonObjectPairs converts a JSON config object to the 'key1=value1,key2=value2'
+// format, with escaped values, so it's parsed like the values of the other
+// sources.
+func jsonObjectPairs(obj map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(obj))
+	for _, key := range keys {
+		if key == "" || strings.ContainsAny(key, "=,") {
+			return "", fmt.Errorf("invalid key '%s'", key)
+		}
+		var val string
+		switch v := obj[key].(type) {
+		case string:
+			val = v
+		case json.Number:
+			val = v.String()
+		case bool:
+			val = strconv.FormatBool(v)
+		default:
+			return "", fmt.Errorf("the value of '%s' should be a string", key)
+		}
+		// PathEscape leaves the commas that separate the pairs as they are.
+		pairs = append(pairs, key+"="+strings.ReplaceAll(url.PathEscape(val), ",", "%2C"))
+	}
+	return strings.Join(pairs, ","), nil
+}
+
+// envOptionName converts an option name to its environment variable name
+// suffix, e.g. pushInterval to PUSH_INTERVAL.
+func envOptionName(name string) string {
+	var sb strings.Builder
+	var prev rune
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(prev) {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+		prev = r
+	}
+	return sb.String()
+}
+
+func (l *configLayers) set(name, value, source string) {
+	l.values[name] = value
+	l.sources[name] = source
+}
+
+func (l *configLayers) get(name string) (string, bool) {
+	val, ok := l.values[name]
+	return val, ok
+}
+
+// source returns where the value of the option came from.
+func (l *configLayers) source(name string) string {
+	if source, ok := l.sources[name]; ok {
+		return source
+	}
+	return "the default config"
+}
+
+func (l *configLayers) invalid(name, value string, err error) error {
+	return fmt.Errorf("invalid %s '%s' in %s: %w", name, value, l.source(name), err)
+}
+
+func (l *configLayers) string(name string, dst *string) {
+	if val, ok := l.get(name); ok {
+		*dst = val
+	}
+}
+
+func (l *configLayers) bool(name string, dst *bool) error {
+	val, ok := l.get(name)
+	if !ok {
+		return nil
+	}
+	v, err := strconv.ParseBool(val)
+	if err != nil {
+		return l.invalid(name, val, err)
+	}
+	*dst = v
+	return nil
+}
+
+func (l *configLayers) int(name string, dst *int) error {
+	val, ok := l.get(name)
+	if !ok {
+		return nil
+	}
+	v, err := strconv.Atoi(val)
+	if err != nil {
+		return l.invalid(name, val, err)
+	}
+	*dst = v
+	return nil
+}
+
+func (l *configLayers) int64(name string, dst *int64) error {
+	val, ok := l.get(name)
+	if !ok {
+		return nil
+	}
+	v, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return l.invalid(name, val, err)
+	}
+	*dst = v
+	return nil
+}
+
+func (l *configLayers) duration(name string, dst *time.Duration) error {
+	val, ok := l.get(name)
+	if !ok {
+		return nil
+	}
+	v, err := time.ParseDuration(val)
+	if err != nil {
+		return l.invalid(name, val, err)
+	}
+	*dst = v
+	return nil
+}
+
+// ratio parses a ratio between 0 and 1, either as a fraction like 0.25 or as
+// a percentage like 25%.
+func (l *configLayers) ratio(name string, dst *float64) error {
+	val, ok := l.get(name)
+	if !ok {
+		return nil
+	}
+	v, err := parseRatio(val)
+	if err != nil {
+		return l.invalid(name, val, err)
+	}
+	*dst = v
+	return nil
+}
+
+func parseRatio(val string) (float64, error) {
+	val = strings.TrimSpace(val)
+	scale := 1.0
+	if strings.HasSuffix(val, "%") {
+		val, scale = strings.TrimSuffix(val, "%"), 100
+	}
+	v, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, err
+	}
+	v /= scale
+	if v < 0 || v > 1 {
+		return 0, fmt.Errorf("should be between 0 and 1, or 0%% and 100%%")
+	}
+	return v, nil
+}
+
+// validationError reports an option value that was parsed fine, but is not
+// acceptable, along with its source.
+func (l *configLayers) validationError(name string, format string, args ...interface{}) error {
+	return fmt.Errorf("%s %s (set by %s)", name, fmt.Sprintf(format, args...), l.source(name))
+}
+
+// headers parses a list of headers in the 'key1=value1,key2=value2' format,
+// the same as the one of OTEL_EXPORTER_OTLP_HEADERS. The values are
+// percent-decoded, but a '+' is kept as it is, since it's common in tokens.
+func (l *configLayers) headers(name string, dst *map[string]string) error {
+	val, ok := l.get(name)
+	if !ok || strings.TrimSpace(val) == "" {
+		return nil
+	}
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return l.invalid(name, val, fmt.Errorf("expected 'key=value' pairs, separated by commas"))
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return l.invalid(name, val, err)
+		}
+		headers[key] = value
+	}
+	*dst = headers
+	return nil
+}
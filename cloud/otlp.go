@@ -0,0 +1,373 @@
+package crocospans
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"go.k6.io/k6/output"
+)
+
+const otlpTracesPath = "/v1/traces"
+
+// OTLP span kind and status codes, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto
+const (
+	otlpSpanKindClient  = 3
+	otlpStatusCodeError = 2
+)
+
+// The types below mirror the ExportTraceServiceRequest message, following the
+// OTLP/JSON encoding rules: trace and span IDs are hex strings and 64 bit
+// integers are strings.
+type (
+	otlpTraceData struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Links             []otlpLink     `json:"links,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+
+	otlpLink struct {
+		TraceID    string         `json:"traceId"`
+		SpanID     string         `json:"spanId"`
+		Attributes []otlpKeyValue `json:"attributes,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+
+	otlpAnyValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	}
+)
+
+func otlpString(key, val string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &val}}
+}
+
+func otlpInt(key string, val int64) otlpKeyValue {
+	str := strconv.FormatInt(val, 10)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &str}}
+}
+
+func otlpBool(key string, val bool) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{BoolValue: &val}}
+}
+
+func otlpTime(unixNano uint64) string {
+	return strconv.FormatUint(unixNano, 10)
+}
+
+// otlpJSONEncoder encodes spans as an OTLP/JSON ExportTraceServiceRequest,
+// with a resource per scenario.
+type otlpJSONEncoder struct{}
+
+func (otlpJSONEncoder) ContentType() string {
+	return "application/json"
+}
+
+func (otlpJSONEncoder) Encode(requests []*Request) ([][]byte, error) {
+	batch, err := json.Marshal(newOTLPTraceData(requests))
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{batch}, nil
+}
+
+func newOTLPTraceData(requests []*Request) otlpTraceData {
+	byScenario := make(map[string][]otlpSpan)
+	testRunIDs := make(map[string]string)
+	for _, req := range requests {
+		byScenario[req.Scenario] = append(byScenario[req.Scenario], newOTLPSpan(req))
+		testRunIDs[req.Scenario] = req.TestRunIDString
+	}
+	scenarios := make([]string, 0, len(byScenario))
+	for scenario := range byScenario {
+		scenarios = append(scenarios, scenario)
+	}
+	sort.Strings(scenarios)
+
+	data := otlpTraceData{ResourceSpans: make([]otlpResourceSpans, 0, len(scenarios))}
+	for _, scenario := range scenarios {
+		attributes := []otlpKeyValue{
+			otlpString("service.name", "k6"),
+			otlpString("k6.test_run_id", testRunIDs[scenario]),
+		}
+		if scenario != "" {
+			attributes = append(attributes, otlpString("k6.scenario", scenario))
+		}
+		data.ResourceSpans = append(data.ResourceSpans, otlpResourceSpans{
+			Resource: otlpResource{Attributes: attributes},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "xk6-distributed-tracing"},
+				Spans: byScenario[scenario],
+			}},
+		})
+	}
+	return data
+}
+
+func newOTLPSpan(req *Request) otlpSpan {
+	span := otlpSpan{
+		TraceID:           req.TraceID,
+		SpanID:            req.SpanID,
+		ParentSpanID:      req.ParentSpanID,
+		Name:              req.HTTPMethod,
+		Kind:              otlpSpanKindClient,
+		StartTimeUnixNano: otlpTime(req.StartTimeUnixNano),
+		EndTimeUnixNano:   otlpTime(req.EndTimeUnixNano),
+		Attributes: []otlpKeyValue{
+			otlpString("http.method", req.HTTPMethod),
+			otlpString("http.url", req.HTTPUrl),
+			otlpInt("http.status_code", req.HTTPStatus),
+			otlpInt("k6.vu", req.VUID),
+			otlpInt("k6.iteration", req.Iteration),
+			otlpBool("k6.expected_response", req.ExpectedResponse),
+		},
+	}
+	if req.Name != "" {
+		span.Name = req.HTTPMethod + " " + req.Name
+		span.Attributes = append(span.Attributes, otlpString("k6.name", req.Name))
+	}
+	if req.Group != "" {
+		span.Attributes = append(span.Attributes, otlpString("k6.group", req.Group))
+	}
+	if req.Proto != "" {
+		span.Attributes = append(span.Attributes, otlpString("http.flavor", req.Proto))
+	}
+	if req.TLSVersion != "" {
+		span.Attributes = append(span.Attributes, otlpString("tls.protocol.version", req.TLSVersion))
+	}
+	if req.RequestBytes > 0 {
+		span.Attributes = append(span.Attributes, otlpInt("http.request_content_length", req.RequestBytes))
+	}
+	if req.ResponseBytes > 0 {
+		span.Attributes = append(span.Attributes, otlpInt("http.response_content_length", req.ResponseBytes))
+	}
+	if req.ErrorCode != 0 {
+		span.Attributes = append(span.Attributes, otlpInt("k6.error_code", req.ErrorCode))
+	}
+	userTags := make([]string, 0, len(req.Tags))
+	for k := range req.Tags {
+		userTags = append(userTags, k)
+	}
+	sort.Strings(userTags)
+	for _, k := range userTags {
+		span.Attributes = append(span.Attributes, otlpString(k, req.Tags[k]))
+	}
+
+	if req.Error != "" || !req.ExpectedResponse {
+		span.Status = otlpStatus{Code: otlpStatusCodeError, Message: req.Error}
+	}
+	for _, phase := range req.Phases {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: otlpTime(phase.StartTimeUnixNano),
+			Name:         phase.Name,
+			Attributes:   []otlpKeyValue{otlpInt("duration_ns", int64(phase.EndTimeUnixNano-phase.StartTimeUnixNano))},
+		})
+	}
+	for _, link := range req.Links {
+		span.Links = append(span.Links, otlpLink{
+			TraceID:    link.TraceID,
+			SpanID:     link.SpanID,
+			Attributes: []otlpKeyValue{otlpString("k6.link_type", link.Type)},
+		})
+	}
+	return span
+}
+
+// NewOTLP creates an output that sends the traced requests to an OTLP/HTTP
+// receiver, like the OpenTelemetry collector or Grafana Tempo, as JSON.
+func NewOTLP(p output.Params) (*Output, error) {
+	conf, _, err := newConfig(p, outputSpec{name: "xk6-otlp", envPrefix: "XK6_OTLP_"})
+	if err != nil {
+		return nil, err
+	}
+
+	// Allow just the receiver address to be specified, e.g. http://localhost:4318
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+		conf.Endpoint = u.String()
+	}
+
+	return newOutput(p, "xk6-otlp", conf, otlpJSONEncoder{})
+}
+
+// requestsFromOTLP converts OTLP/JSON spans, as written by otlpJSONEncoder,
+// back to requests. Attributes that don't map to a Request field become tags.
+func requestsFromOTLP(data otlpTraceData) ([]*Request, error) {
+	var requests []*Request
+	for _, rs := range data.ResourceSpans {
+		var testRunID, scenario string
+		for _, attr := range rs.Resource.Attributes {
+			switch attr.Key {
+			case "k6.test_run_id":
+				testRunID = attr.Value.string()
+			case "k6.scenario":
+				scenario = attr.Value.string()
+			}
+		}
+
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				req, err := requestFromOTLPSpan(span)
+				if err != nil {
+					return nil, err
+				}
+				req.setTestRunID(testRunID)
+				req.Scenario = scenario
+				requests = append(requests, req)
+			}
+		}
+	}
+	return requests, nil
+}
+
+func requestFromOTLPSpan(span otlpSpan) (*Request, error) {
+	start, err := strconv.ParseUint(span.StartTimeUnixNano, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid span start time '%s': %w", span.StartTimeUnixNano, err)
+	}
+	end, err := strconv.ParseUint(span.EndTimeUnixNano, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid span end time '%s': %w", span.EndTimeUnixNano, err)
+	}
+
+	req := &Request{
+		TraceID:           span.TraceID,
+		SpanID:            span.SpanID,
+		ParentSpanID:      span.ParentSpanID,
+		StartTimeUnixNano: start,
+		EndTimeUnixNano:   end,
+		ExpectedResponse:  true,
+	}
+	for _, attr := range span.Attributes {
+		switch attr.Key {
+		case "http.method":
+			req.HTTPMethod = attr.Value.string()
+		case "http.url":
+			req.HTTPUrl = attr.Value.string()
+		case "http.status_code":
+			req.HTTPStatus = attr.Value.int()
+		case "k6.vu":
+			req.VUID = attr.Value.int()
+		case "k6.iteration":
+			req.Iteration = attr.Value.int()
+		case "k6.expected_response":
+			req.ExpectedResponse = attr.Value.BoolValue == nil || *attr.Value.BoolValue
+		case "k6.name":
+			req.Name = attr.Value.string()
+		case "k6.group":
+			req.Group = attr.Value.string()
+		case "http.flavor":
+			req.Proto = attr.Value.string()
+		case "tls.protocol.version":
+			req.TLSVersion = attr.Value.string()
+		case "http.request_content_length":
+			req.RequestBytes = attr.Value.int()
+		case "http.response_content_length":
+			req.ResponseBytes = attr.Value.int()
+		case "k6.error_code":
+			req.ErrorCode = attr.Value.int()
+		default:
+			if req.Tags == nil {
+				req.Tags = make(map[string]string)
+			}
+			req.Tags[attr.Key] = attr.Value.string()
+		}
+	}
+	req.Error = span.Status.Message
+
+	for _, event := range span.Events {
+		t, err := strconv.ParseUint(event.TimeUnixNano, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid span event time '%s': %w", event.TimeUnixNano, err)
+		}
+		phase := &Phase{Name: event.Name, StartTimeUnixNano: t, EndTimeUnixNano: t}
+		for _, attr := range event.Attributes {
+			if attr.Key == "duration_ns" {
+				phase.EndTimeUnixNano += uint64(attr.Value.int())
+			}
+		}
+		req.Phases = append(req.Phases, phase)
+	}
+	for _, link := range span.Links {
+		l := &Link{TraceID: link.TraceID, SpanID: link.SpanID}
+		for _, attr := range link.Attributes {
+			if attr.Key == "k6.link_type" {
+				l.Type = attr.Value.string()
+			}
+		}
+		req.Links = append(req.Links, l)
+	}
+	return req, nil
+}
+
+func (v otlpAnyValue) string() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	default:
+		return ""
+	}
+}
+
+func (v otlpAnyValue) int() int64 {
+	i, _ := strconv.ParseInt(v.string(), 10, 64)
+	return i
+}
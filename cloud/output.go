@@ -1,16 +1,15 @@
 package crocospans
 
 import (
-	"bytes"
 	"fmt"
-	"math/rand"
+	"io"
 	"net/http"
-	"strconv"
+	"os"
 	sync "sync"
-	"unsafe"
+	"time"
 
 	"github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/proto"
+	"github.com/spf13/afero"
 
 	"go.k6.io/k6/lib/netext/httpext"
 	"go.k6.io/k6/metrics"
@@ -19,16 +18,38 @@ import (
 
 // Output implements the k6 output.Output interface
 type Output struct {
+	name   string
 	config Config
 
-	testRunID int64
+	encoder      batchEncoder
+	authenticate func(*http.Request) error
+	httpClient   *http.Client
 
-	httpClient *http.Client
+	// send delivers a single encoded batch, by default with an HTTP push
+	// to the configured endpoint.
+	send   func(batch []byte) error
+	closer io.Closer
 
 	bufferLock sync.Mutex
 	buffer     []*httpext.Trail
+	// spanSamples are the other samples that are turned into spans, like the
+	// ones of traced WebSocket sessions.
+	spanSamples []metrics.Sample
+
+	redirects *redirectTracker
+
+	// report collects the slowest and failed traced requests, if the report
+	// is enabled, and is written to reportOut and the report file at Stop.
+	report    *reportCollector
+	reportOut io.Writer
+	fs        afero.Fs
 
 	periodicFlusher *output.PeriodicFlusher
+	pushQueue       chan pushBatch
+	pushWG          sync.WaitGroup
+	stats           outputStats
+	stopStatsLogger chan struct{}
+	statsLoggerDone chan struct{}
 	logger          logrus.FieldLogger
 }
 
@@ -41,15 +62,45 @@ func New(p output.Params) (*Output, error) {
 		return nil, err
 	}
 
-	return &Output{
-		config:     conf,
-		logger:     p.Logger.WithField("component", "xk6-crocospans-output"),
-		httpClient: http.DefaultClient, // TODO: some options here?
-	}, nil
+	return newOutput(p, "xk6-crocospans", conf, protoEncoder{})
+}
+
+// newOutput creates an output with the shared buffering and pushing machinery,
+// which sends the spans encoded with the given encoder.
+func newOutput(p output.Params, name string, conf Config, encoder batchEncoder) (*Output, error) {
+	httpClient, err := newHTTPClient(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Output{
+		name:         name,
+		config:       conf,
+		encoder:      encoder,
+		authenticate: newAuthenticator(conf).authenticate,
+		redirects:    newRedirectTracker(),
+		logger:       p.Logger.WithField("component", name+"-output"),
+		httpClient:   httpClient,
+	}
+	if conf.Report.enabled() {
+		o.report = newReportCollector(conf.Report)
+		// The report isn't written to stdout, which may be taken by the spans
+		// file output.
+		o.reportOut = p.StdErr
+		if o.reportOut == nil {
+			o.reportOut = os.Stderr
+		}
+		o.fs = p.FS
+		if o.fs == nil {
+			o.fs = afero.NewOsFs()
+		}
+	}
+	o.send = o.push
+	return o, nil
 }
 
 func (o *Output) Description() string {
-	return fmt.Sprintf("xk6-crocospans (TestRunID: %d)", o.testRunID)
+	return fmt.Sprintf("%s (TestRunID: %s)", o.name, o.config.TestRunID)
 }
 
 // AddMetricSamples adds the given metric samples to the internal buffer.
@@ -60,10 +111,14 @@ func (o *Output) AddMetricSamples(samples []metrics.SampleContainer) {
 	o.bufferLock.Lock()
 	defer o.bufferLock.Unlock()
 	for _, s := range samples {
-		// Only collect HTTP request samples for now
-		// TODO: do some sort of sampling or processing?
 		if httpSample, ok := s.(*httpext.Trail); ok {
 			o.buffer = append(o.buffer, httpSample)
+			continue
+		}
+		for _, sample := range s.GetSamples() {
+			if isSpanSample(sample) {
+				o.spanSamples = append(o.spanSamples, sample)
+			}
 		}
 	}
 }
@@ -72,94 +127,195 @@ func (o *Output) Stop() error {
 	o.logger.Debug("Stopping...")
 	defer o.logger.Debug("Stopped!")
 	o.periodicFlusher.Stop()
+	o.stopPushers()
+	if o.stopStatsLogger != nil {
+		close(o.stopStatsLogger)
+		<-o.statsLoggerDone
+	}
+	stats := o.logStats(Stats{}, "Spans summary")
+	o.writeReport()
 
-	// TODO: do we need to do something here?
-
+	if o.closer != nil {
+		if err := o.closer.Close(); err != nil {
+			return err
+		}
+	}
+	// k6 only logs this error, the run doesn't fail because of it.
+	if stats.SpansDropped > 0 {
+		return fmt.Errorf("%d of %d spans were dropped", stats.SpansDropped, stats.SpansBuffered)
+	}
 	return nil
 }
 
+// Stats returns the totals of the spans the output has taken so far, which
+// are final once it's stopped.
+func (o *Output) Stats() Stats {
+	stats, _ := o.stats.snapshot()
+	return stats
+}
+
+// logStats logs a summary of the spans pushed since the prev totals, which is
+// a warning if some of them didn't reach the backend, or an info if some
+// pushes failed. It returns the current totals.
+func (o *Output) logStats(prev Stats, msg string) Stats {
+	stats, lastErr := o.stats.snapshot()
+	delta := stats.since(prev)
+	logger := o.logger.WithFields(logrus.Fields{
+		"buffered":    delta.SpansBuffered,
+		"sent":        delta.SpansSent,
+		"dropped":     delta.SpansDropped,
+		"retried":     delta.SpansRetried,
+		"push_errors": delta.PushErrors,
+	})
+	if lastErr != nil && delta.PushErrors > 0 {
+		logger = logger.WithField("last_error", lastErr.Error())
+	}
+	switch {
+	case delta.SpansDropped > 0:
+		logger.Warn(msg + ", some spans were dropped")
+	case delta.PushErrors > 0:
+		logger.Info(msg)
+	default:
+		logger.Debug(msg)
+	}
+	return stats
+}
+
+// startStatsLogger periodically logs a summary of the spans pushed since the
+// previous one, so problems show up during long tests.
+func (o *Output) startStatsLogger() {
+	if o.config.LogStatsInterval <= 0 {
+		return
+	}
+	o.stopStatsLogger = make(chan struct{})
+	o.statsLoggerDone = make(chan struct{})
+	go func() {
+		defer close(o.statsLoggerDone)
+		ticker := time.NewTicker(o.config.LogStatsInterval)
+		defer ticker.Stop()
+		var prev Stats
+		for {
+			select {
+			case <-ticker.C:
+				prev = o.logStats(prev, "Spans pushed since the previous summary")
+			case <-o.stopStatsLogger:
+				return
+			}
+		}
+	}()
+}
+
+// writeReport writes the report of the slowest and failed traced requests,
+// if it's enabled.
+func (o *Output) writeReport() {
+	if o.report == nil {
+		return
+	}
+	report := o.report.report(o.config.TestRunID)
+	if report.DroppedGroups > 0 {
+		o.logger.WithField("dropped", report.DroppedGroups).Warn(
+			"Too many scenario and URL pairs for the report of the slowest requests, use the name tag to group URLs")
+	}
+	if err := writeReportTable(o.reportOut, report); err != nil {
+		o.logger.WithError(err).Error("Failed to write the report of the traced requests")
+	}
+	if o.config.Report.File == "" {
+		return
+	}
+	if err := writeReportFile(o.fs, o.config.Report.File, report); err != nil {
+		o.logger.WithError(err).Error("Failed to write the report file of the traced requests")
+	}
+}
+
 func (o *Output) Start() error {
 	o.logger.Debug("Starting...")
 
-	// TODO: initial set up we need to do? get the test run ID somehow?
-	o.testRunID = 10000 + rand.Int63n(99999-10000)
-
+	o.startPushers()
 	pf, err := output.NewPeriodicFlusher(o.config.PushInterval, o.flushMetrics)
 	if err != nil {
+		o.stopPushers()
 		return err
 	}
 	o.logger.Debug("Started!")
 	o.periodicFlusher = pf
+	o.startStatsLogger()
 
 	return nil
 }
 
+// AddRequests encodes and pushes already converted requests, e.g. ones that
+// were read back from a spans file. Unlike the metric samples, they aren't
+// dropped when the push queue is full; instead, the call blocks until there is
+// space in it. It can only be used between Start and Stop.
+func (o *Output) AddRequests(requests []*Request) error {
+	o.stats.record(Stats{SpansBuffered: int64(len(requests))}, nil)
+	batches, err := o.encode(requests)
+	if err != nil {
+		o.stats.record(Stats{SpansDropped: int64(len(requests))}, err)
+		return err
+	}
+	for _, batch := range batches {
+		o.pushQueue <- batch
+	}
+	return nil
+}
+
 func (o *Output) flushMetrics() {
 	o.bufferLock.Lock()
 	bufferedTrails := o.buffer
 	o.buffer = make([]*httpext.Trail, 0, len(bufferedTrails)) // TODO: optimize like output.SampleBuffer?
+	spanSamples := o.spanSamples
+	o.spanSamples = nil
 	o.bufferLock.Unlock()
 
-	// TODO: do some sort of sampling or processing?
+	now := time.Now()
+	defer o.redirects.prune(now)
 
-	requests := make([]*Request, 0, len(bufferedTrails))
+	requests := make([]*Request, 0, len(bufferedTrails)+len(spanSamples))
 
 	for _, trail := range bufferedTrails {
-		traceID, hasTrace := trail.Metadata["trace_id"]
-		if !hasTrace {
+		if _, hasTrace := trail.Metadata["trace_id"]; !hasTrace {
 			continue
 		}
 
-		totalDuration := trail.Blocked + trail.ConnDuration + trail.Duration
-		startTime := trail.EndTime.Add(-totalDuration)
-
-		getTag := func(name string) string {
-			val, _ := trail.Tags.Get(name)
-			return val
-		}
-
-		strStatus := getTag("status")
-		status, err := strconv.ParseInt(strStatus, 10, 64)
+		req, err := newRequest(trail, o.config.TestRunID)
 		if err != nil {
-			o.logger.Warnf("unexpected error parsing status '%s': %w", strStatus, err)
+			o.logger.WithError(err).Warn("Skipping traced request")
 			continue
 		}
-
-		req := &Request{
-			TestRunID:         o.testRunID,
-			StartTimeUnixNano: uint64(startTime.UnixNano()),
-			EndTimeUnixNano:   uint64(trail.EndTime.UnixNano()),
-			Group:             getTag("group"),
-			Scenario:          getTag("scenario"),
-			TraceID:           traceID,
-			HTTPUrl:           getTag("url"),
-			HTTPMethod:        getTag("method"),
-			HTTPStatus:        status,
+		o.redirects.track(req, trail.Metadata["call_id"], now)
+		keep := o.config.Sampling.keep(req)
+		if o.report != nil {
+			o.report.record(req, trail.Duration, keep)
+		}
+		if !keep {
+			continue
+		}
+		if o.config.PhaseSpans {
+			req.Phases = newPhases(trail)
 		}
 
 		requests = append(requests, req)
 	}
-
-	md := &RequestBatch{
-		// TODO: FIXME: unsafe.Sizeof() here is almost certainly a bug and both
-		// Count and SizeBytes should be unnecessary
-		SizeBytes: int64(unsafe.Sizeof(requests)),
-		Count:     int64(len(requests)),
-		Requests:  requests,
+	for _, sample := range spanSamples {
+		if req := newSampleRequest(sample, o.config.TestRunID); o.config.Sampling.keep(req) {
+			requests = append(requests, req)
+		}
 	}
 
-	mm, err := proto.Marshal(md)
-	if err != nil {
-		logrus.WithError(err).Error("Failed to marshal request metadata")
+	if len(requests) == 0 {
+		return
 	}
+	o.stats.record(Stats{SpansBuffered: int64(len(requests))}, nil)
 
-	rq, _ := http.NewRequest("POST", o.config.Endpoint, bytes.NewBuffer(mm))
-	orgID := strconv.Itoa(int(o.config.OrgID))
-	rq.Header.Add("X-Scope-OrgID", orgID)
-	rq.SetBasicAuth(orgID, o.config.Token)
-	_, err = o.httpClient.Do(rq)
+	batches, err := o.encode(requests)
 	if err != nil {
-		logrus.WithError(err).Error("Failed to send request metadata")
+		o.logger.WithError(err).Error("Failed to marshal request metadata")
+		o.stats.record(Stats{SpansDropped: int64(len(requests))}, err)
+		return
 	}
 
+	for _, batch := range batches {
+		o.enqueue(batch)
+	}
 }
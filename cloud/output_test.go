@@ -0,0 +1,682 @@
+package crocospans
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"go.k6.io/k6/lib/netext/httpext"
+	"go.k6.io/k6/lib/testutils"
+	"go.k6.io/k6/metrics"
+	"go.k6.io/k6/output"
+)
+
+func newTestOutput(t *testing.T, endpoint string, env map[string]string) *Output {
+	t.Helper()
+
+	environment := map[string]string{
+		"XK6_CROCOSPANS_ORG_ID": "1",
+		"XK6_CROCOSPANS_TOKEN":  "token",
+	}
+	for k, v := range env {
+		environment[k] = v
+	}
+
+	o, err := New(output.Params{
+		ConfigArgument: endpoint,
+		Environment:    environment,
+		Logger:         testutils.NewLogger(t),
+	})
+	require.NoError(t, err)
+	return o
+}
+
+var testSpanIDs uint64
+
+func newTestTrail(traceID string) *httpext.Trail {
+	tags := metrics.NewRegistry().RootTagSet().WithTagsFromMap(map[string]string{
+		"status":   "200",
+		"method":   http.MethodGet,
+		"url":      "http://example.com",
+		"scenario": "default",
+	})
+	return &httpext.Trail{
+		EndTime:   time.Now(),
+		Duration:  10 * time.Millisecond,
+		Sending:   1 * time.Millisecond,
+		Waiting:   8 * time.Millisecond,
+		Receiving: 1 * time.Millisecond,
+		Tags:      tags,
+		Metadata:  map[string]string{"trace_id": traceID, "span_id": fmt.Sprintf("%016x", atomic.AddUint64(&testSpanIDs, 1))},
+	}
+}
+
+func TestOutputDoesNotStallOnSlowEndpoint(t *testing.T) {
+	t.Parallel()
+
+	const delay = 300 * time.Millisecond
+	var received int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		batch := &RequestBatch{}
+		assert.NoError(t, proto.Unmarshal(body, batch))
+		time.Sleep(delay)
+		atomic.AddInt64(&received, int64(len(batch.Requests)))
+	}))
+	defer srv.Close()
+
+	o := newTestOutput(t, srv.URL, map[string]string{
+		"XK6_CROCOSPANS_PUSH_INTERVAL":    "1h",
+		"XK6_CROCOSPANS_PUSH_CONCURRENCY": "4",
+	})
+	require.NoError(t, o.Start())
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		o.AddMetricSamples([]metrics.SampleContainer{newTestTrail("abcdef")})
+		o.flushMetrics()
+	}
+	assert.Less(t, time.Since(start), delay, "flushing should not wait for the pushes")
+
+	require.NoError(t, o.Stop())
+	assert.Less(t, time.Since(start), 2*delay, "pushes should be sent concurrently")
+	assert.Equal(t, int64(4), atomic.LoadInt64(&received))
+}
+
+func TestOutputDropsBatchesWhenQueueIsFull(t *testing.T) {
+	t.Parallel()
+
+	var received int64
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		atomic.AddInt64(&received, 1)
+	}))
+	defer srv.Close()
+
+	o := newTestOutput(t, srv.URL, map[string]string{
+		"XK6_CROCOSPANS_PUSH_INTERVAL":    "1h",
+		"XK6_CROCOSPANS_PUSH_CONCURRENCY": "1",
+		"XK6_CROCOSPANS_PUSH_QUEUE_SIZE":  "1",
+	})
+	require.NoError(t, o.Start())
+
+	o.AddMetricSamples([]metrics.SampleContainer{newTestTrail("abcdef")})
+	o.flushMetrics()
+	// Wait for the only sender to pick up the first batch and block on it.
+	require.Eventually(t, func() bool { return len(o.pushQueue) == 0 }, time.Second, time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		o.AddMetricSamples([]metrics.SampleContainer{newTestTrail("abcdef")})
+		o.flushMetrics()
+	}
+
+	close(unblock)
+	assert.EqualError(t, o.Stop(), "2 of 4 spans were dropped")
+	assert.Equal(t, int64(2), atomic.LoadInt64(&received))
+	stats, _ := o.stats.snapshot()
+	assert.Equal(t, int64(2), stats.SpansSent)
+	assert.Equal(t, int64(2), stats.SpansDropped)
+}
+
+func TestOutputRetriesAndStats(t *testing.T) {
+	t.Parallel()
+
+	var attempts int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch n := atomic.AddInt64(&attempts, 1); {
+		case n <= 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Query().Get("reject") != "":
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	o := newTestOutput(t, srv.URL, map[string]string{
+		"XK6_CROCOSPANS_PUSH_INTERVAL":    "1h",
+		"XK6_CROCOSPANS_PUSH_RETRY_DELAY": "1ms",
+	})
+	require.NoError(t, o.Start())
+	o.AddMetricSamples([]metrics.SampleContainer{newTestTrail("abcdef"), newTestTrail("abcdef")})
+	require.NoError(t, o.Stop())
+
+	// The two spans are counted as retried once, although their push was
+	// retried twice.
+	stats, lastErr := o.stats.snapshot()
+	assert.Equal(t, int64(3), atomic.LoadInt64(&attempts))
+	assert.Equal(t, int64(2), stats.SpansBuffered)
+	assert.Equal(t, int64(2), stats.SpansSent)
+	assert.Equal(t, int64(2), stats.SpansRetried)
+	assert.Equal(t, int64(0), stats.SpansDropped)
+	assert.Equal(t, int64(2), stats.PushErrors)
+	assert.EqualError(t, lastErr, "unexpected response status 503 Service Unavailable")
+
+	// Client errors aren't retried.
+	o = newTestOutput(t, srv.URL+"?reject=1", map[string]string{"XK6_CROCOSPANS_PUSH_INTERVAL": "1h"})
+	require.NoError(t, o.Start())
+	o.AddMetricSamples([]metrics.SampleContainer{newTestTrail("abcdef")})
+	assert.EqualError(t, o.Stop(), "1 of 1 spans were dropped")
+
+	stats, lastErr = o.stats.snapshot()
+	assert.Equal(t, int64(4), atomic.LoadInt64(&attempts))
+	assert.Equal(t, int64(0), stats.SpansSent)
+	assert.Equal(t, int64(0), stats.SpansRetried)
+	assert.Equal(t, int64(1), stats.SpansDropped)
+	assert.EqualError(t, lastErr, "unexpected response status 400 Bad Request")
+}
+
+func TestOutputStatsOfAddedRequests(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("reject") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	requests := []*Request{{TraceID: "abcdef", SpanID: "123456"}, {TraceID: "abcdef", SpanID: "123457"}}
+	o := newTestOutput(t, srv.URL, map[string]string{"XK6_CROCOSPANS_PUSH_INTERVAL": "1h"})
+	require.NoError(t, o.Start())
+	require.NoError(t, o.AddRequests(requests))
+	require.NoError(t, o.Stop())
+	stats := o.Stats()
+	assert.Equal(t, int64(2), stats.SpansBuffered)
+	assert.Equal(t, int64(2), stats.SpansSent)
+	assert.Equal(t, int64(0), stats.SpansDropped)
+
+	o = newTestOutput(t, srv.URL+"?reject=1", map[string]string{"XK6_CROCOSPANS_PUSH_INTERVAL": "1h"})
+	require.NoError(t, o.Start())
+	require.NoError(t, o.AddRequests(requests))
+	assert.EqualError(t, o.Stop(), "2 of 2 spans were dropped")
+	stats = o.Stats()
+	assert.Equal(t, int64(0), stats.SpansSent)
+	assert.Equal(t, int64(2), stats.SpansDropped)
+}
+
+func TestOutputLogsStatsPeriodically(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	logger, hook := logtest.NewNullLogger()
+	o, err := New(output.Params{
+		ConfigArgument: srv.URL,
+		Logger:         logger,
+		Environment: map[string]string{
+			"XK6_CROCOSPANS_ORG_ID":             "1",
+			"XK6_CROCOSPANS_TOKEN":              "token",
+			"XK6_CROCOSPANS_PUSH_INTERVAL":      "1h",
+			"XK6_CROCOSPANS_LOG_STATS_INTERVAL": "10ms",
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, o.Start())
+	o.AddMetricSamples([]metrics.SampleContainer{newTestTrail("abcdef")})
+	o.flushMetrics()
+
+	// The drop is logged during the test, before the summary of Stop.
+	require.Eventually(t, func() bool {
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel && entry.Data["dropped"] == int64(1) {
+				return entry.Data["last_error"] == "unexpected response status 400 Bad Request"
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+	require.Error(t, o.Stop())
+
+	// The final summary covers the whole test.
+	last := hook.LastEntry()
+	assert.Equal(t, logrus.WarnLevel, last.Level)
+	assert.Equal(t, int64(1), last.Data["dropped"])
+}
+
+func TestOutputTestRunID(t *testing.T) {
+	t.Parallel()
+
+	o := newTestOutput(t, "http://localhost", map[string]string{"K6_CLOUDRUN_TEST_RUN_ID": "1234"})
+	assert.Equal(t, "xk6-crocospans (TestRunID: 1234)", o.Description())
+
+	for id, env := range map[string]map[string]string{
+		"1234": {"K6_CLOUDRUN_TEST_RUN_ID": "1234", "K6_CLOUD_TEST_RUN_ID": "5678", "K6_CLOUD_PUSH_REF_ID": "9012"},
+		"5678": {"K6_CLOUD_TEST_RUN_ID": "5678", "K6_CLOUD_PUSH_REF_ID": "9012"},
+		"9012": {"K6_CLOUD_PUSH_REF_ID": "9012"},
+	} {
+		assert.Equal(t, id, newTestOutput(t, "http://localhost", env).config.TestRunID, env)
+	}
+
+	o = newTestOutput(t, "http://localhost", map[string]string{
+		"K6_CLOUDRUN_TEST_RUN_ID":    "1234",
+		"XK6_CROCOSPANS_TEST_RUN_ID": "custom",
+	})
+	assert.Equal(t, "custom", o.config.TestRunID)
+
+	local1 := newTestOutput(t, "http://localhost", nil).config.TestRunID
+	local2 := newTestOutput(t, "http://localhost", nil).config.TestRunID
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, local1)
+	assert.NotEqual(t, local1, local2)
+}
+
+func TestRequestTestRunIDFields(t *testing.T) {
+	t.Parallel()
+
+	req, err := newRequest(newTestTrail("abcdef"), "1234")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1234), req.TestRunID)
+	assert.Equal(t, "1234", req.TestRunIDString)
+
+	// The numeric ID is still sent in the field the crocospans backend reads.
+	data, err := proto.Marshal(req)
+	require.NoError(t, err)
+	var field4 uint64
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		require.Greater(t, n, 0)
+		data = data[n:]
+		if num == 4 {
+			require.Equal(t, protowire.VarintType, typ)
+			field4, n = protowire.ConsumeVarint(data)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		require.Greater(t, n, 0)
+		data = data[n:]
+	}
+	assert.Equal(t, uint64(1234), field4)
+
+	req, err = newRequest(newTestTrail("abcdef"), "custom")
+	require.NoError(t, err)
+	assert.Zero(t, req.TestRunID)
+	assert.Equal(t, "custom", req.TestRunIDString)
+}
+
+func TestOutputProfiles(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		err    string
+		header http.Header
+	}{
+		{
+			name: "GrafanaCloud",
+			env:  map[string]string{"XK6_CROCOSPANS_ORG_ID": "1", "K6_CLOUD_TOKEN": "token"},
+			header: http.Header{
+				"Authorization": {"Basic MTp0b2tlbg=="},
+				"X-Scope-Orgid": {"1"},
+			},
+		},
+		{
+			name: "GrafanaCloudWithoutOrgID",
+			env:  map[string]string{"K6_CLOUD_TOKEN": "token"},
+			err:  "XK6_CROCOSPANS_ORG_ID is required for the grafana-cloud profile",
+		},
+		{
+			name: "GrafanaCloudWithoutToken",
+			env:  map[string]string{"XK6_CROCOSPANS_PROFILE": "grafana-cloud", "XK6_CROCOSPANS_ORG_ID": "1"},
+			err:  "XK6_CROCOSPANS_TOKEN or K6_CLOUD_TOKEN is required for the grafana-cloud profile",
+		},
+		{
+			name:   "Generic",
+			env:    map[string]string{"XK6_CROCOSPANS_PROFILE": "generic"},
+			header: http.Header{"Authorization": nil, "X-Scope-Orgid": nil},
+		},
+		{
+			name: "GenericWithOrgID",
+			env:  map[string]string{"XK6_CROCOSPANS_PROFILE": "generic", "XK6_CROCOSPANS_ORG_ID": "7"},
+			header: http.Header{
+				"Authorization": nil,
+				"X-Scope-Orgid": {"7"},
+			},
+		},
+		{
+			name:   "GenericWithToken",
+			env:    map[string]string{"XK6_CROCOSPANS_PROFILE": "generic", "XK6_CROCOSPANS_TOKEN": "secret"},
+			header: http.Header{"Authorization": {"Bearer secret"}, "X-Scope-Orgid": nil},
+		},
+		{
+			name: "Unknown",
+			env:  map[string]string{"XK6_CROCOSPANS_PROFILE": "tempo"},
+			err:  "profile should be grafana-cloud or generic but was 'tempo'",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			received := make(chan http.Header, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Header
+			}))
+			defer srv.Close()
+
+			o, err := New(output.Params{
+				ConfigArgument: srv.URL,
+				Environment:    tt.env,
+				Logger:         testutils.NewLogger(t),
+			})
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			require.NoError(t, o.Start())
+			o.AddMetricSamples([]metrics.SampleContainer{newTestTrail("abcdef")})
+			require.NoError(t, o.Stop())
+
+			header := <-received
+			for key, want := range tt.header {
+				assert.Equal(t, want, header.Values(key), key)
+			}
+		})
+	}
+}
+
+func TestNewRequestFromTrail(t *testing.T) {
+	t.Parallel()
+
+	end := time.Unix(1000, 0)
+	trail := &httpext.Trail{
+		EndTime:  end,
+		Blocked:  time.Millisecond,
+		Duration: 9 * time.Millisecond,
+		Tags: metrics.NewRegistry().RootTagSet().WithTagsFromMap(map[string]string{
+			"status":            "503",
+			"method":            http.MethodPost,
+			"url":               "http://example.com/api",
+			"name":              "api",
+			"scenario":          "default",
+			"expected_response": "false",
+			"error_code":        "1503",
+			"proto":             "HTTP/1.1",
+			"team":              "sre",
+		}),
+		Metadata: map[string]string{
+			"trace_id":       "abcdef",
+			"span_id":        "123456",
+			"parent_span_id": "654321",
+			"vu":             "3",
+			"iter":           "7",
+			"request_bytes":  "42",
+			"response_bytes": "1024",
+		},
+	}
+
+	req, err := newRequest(trail, "run")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(end.Add(-10*time.Millisecond).UnixNano()), req.StartTimeUnixNano)
+	assert.Equal(t, "run", req.TestRunIDString)
+	assert.Zero(t, req.TestRunID)
+	assert.Equal(t, "123456", req.SpanID)
+	assert.Equal(t, "654321", req.ParentSpanID)
+	assert.Equal(t, int64(3), req.VUID)
+	assert.Equal(t, int64(7), req.Iteration)
+	assert.Equal(t, int64(503), req.HTTPStatus)
+	assert.Equal(t, int64(1503), req.ErrorCode)
+	assert.False(t, req.ExpectedResponse)
+	assert.Equal(t, "api", req.Name)
+	assert.Equal(t, "HTTP/1.1", req.Proto)
+	assert.Equal(t, int64(42), req.RequestBytes)
+	assert.Equal(t, int64(1024), req.ResponseBytes)
+	assert.Equal(t, map[string]string{"team": "sre"}, req.Tags)
+}
+
+func TestNewRequestFromWSSamples(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	sessionMetric := registry.MustNewMetric(metrics.WSSessionDurationName, metrics.Trend, metrics.Time)
+	messageMetric := registry.MustNewMetric(MetricWSMessages, metrics.Counter)
+	tags := registry.RootTagSet().WithTagsFromMap(map[string]string{
+		"url":      "ws://example.com/chat",
+		"status":   "101",
+		"scenario": "default",
+	})
+	start := time.Unix(1000, 0)
+
+	session := metrics.Sample{
+		TimeSeries: metrics.TimeSeries{Metric: sessionMetric, Tags: tags},
+		Time:       start,
+		Value:      250,
+		Metadata:   map[string]string{"trace_id": "abcdef", "span_id": "123456", "vu": "2", "iter": "5"},
+	}
+	require.True(t, isSpanSample(session))
+	req := newSampleRequest(session, "run")
+	assert.Equal(t, uint64(start.UnixNano()), req.StartTimeUnixNano)
+	assert.Equal(t, uint64(start.Add(250*time.Millisecond).UnixNano()), req.EndTimeUnixNano)
+	assert.Equal(t, "GET", req.HTTPMethod)
+	assert.Equal(t, int64(101), req.HTTPStatus)
+	assert.Equal(t, int64(2), req.VUID)
+	assert.True(t, req.ExpectedResponse)
+	assert.Empty(t, req.ParentSpanID)
+
+	message := metrics.Sample{
+		TimeSeries: metrics.TimeSeries{Metric: messageMetric, Tags: tags},
+		Time:       start.Add(time.Millisecond),
+		Value:      1,
+		Metadata:   map[string]string{"trace_id": "abcdef", "span_id": "654321", "parent_span_id": "123456"},
+	}
+	require.True(t, isSpanSample(message))
+	req = newSampleRequest(message, "run")
+	assert.Equal(t, "SEND", req.HTTPMethod)
+	assert.Equal(t, "123456", req.ParentSpanID)
+	assert.Equal(t, req.StartTimeUnixNano, req.EndTimeUnixNano)
+
+	untraced := session
+	untraced.Metadata = nil
+	assert.False(t, isSpanSample(untraced))
+}
+
+func TestNewRequestFromInjectedSpanSample(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	injected := metrics.Sample{
+		TimeSeries: metrics.TimeSeries{
+			Metric: registry.MustNewMetric(MetricInjectedSpans, metrics.Counter),
+			Tags:   registry.RootTagSet().With("scenario", "producer"),
+		},
+		Time:  time.Unix(1000, 0),
+		Value: 1,
+		Metadata: map[string]string{
+			"trace_id":       "abcdef",
+			"span_id":        "654321",
+			"parent_span_id": "123456",
+			"name":           "produce orders",
+			"vu":             "3",
+		},
+	}
+	require.True(t, isSpanSample(injected))
+	req := newSampleRequest(injected, "run")
+	assert.Equal(t, "INJECT", req.HTTPMethod)
+	assert.Equal(t, "produce orders", req.Name)
+	assert.Equal(t, "123456", req.ParentSpanID)
+	assert.Equal(t, "producer", req.Scenario)
+	assert.Equal(t, int64(3), req.VUID)
+	assert.True(t, req.ExpectedResponse)
+	assert.Equal(t, req.StartTimeUnixNano, req.EndTimeUnixNano)
+}
+
+func TestServerLinksAreAddedToTheLastHop(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan *RequestBatch, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		batch := &RequestBatch{}
+		assert.NoError(t, proto.Unmarshal(body, batch))
+		received <- batch
+	}))
+	defer srv.Close()
+
+	first, redirected := newTestTrail("abcdef"), newTestTrail("abcdef")
+	first.Tags = first.Tags.With("status", "302")
+	redirected.Metadata = map[string]string{
+		"trace_id":      "abcdef",
+		"span_id":       first.Metadata["span_id"],
+		"link_trace_id": "fedcba",
+		"link_span_id":  "654321",
+	}
+
+	// The link is on the trail of the last hop, so it isn't lost even when
+	// the hops are flushed separately.
+	o := newTestOutput(t, srv.URL, map[string]string{"XK6_CROCOSPANS_PUSH_INTERVAL": "1h"})
+	require.NoError(t, o.Start())
+	o.AddMetricSamples([]metrics.SampleContainer{first})
+	o.flushMetrics()
+	o.AddMetricSamples([]metrics.SampleContainer{redirected})
+	require.NoError(t, o.Stop())
+
+	// The batches may be pushed concurrently, so they can arrive in any order.
+	hops := make(map[string]*Request)
+	for i := 0; i < 2; i++ {
+		batch := <-received
+		require.Len(t, batch.Requests, 1)
+		hops[batch.Requests[0].SpanID] = batch.Requests[0]
+	}
+	require.Contains(t, hops, first.Metadata["span_id"])
+	assert.Empty(t, hops[first.Metadata["span_id"]].Links)
+	delete(hops, first.Metadata["span_id"])
+	require.Len(t, hops, 1)
+	for _, last := range hops {
+		require.Len(t, last.Links, 2)
+		assert.Equal(t, &Link{TraceID: "fedcba", SpanID: "654321", Type: LinkTypeServer}, last.Links[0])
+		assert.Equal(t, LinkTypeRedirect, last.Links[1].Type)
+	}
+}
+
+func TestNewPhasesFromTrail(t *testing.T) {
+	t.Parallel()
+
+	end := time.Unix(1000, 0)
+	trail := &httpext.Trail{
+		EndTime:        end,
+		Blocked:        6 * time.Millisecond,
+		ConnDuration:   5 * time.Millisecond,
+		Connecting:     2 * time.Millisecond,
+		TLSHandshaking: 3 * time.Millisecond,
+		Duration:       10 * time.Millisecond,
+		Sending:        1 * time.Millisecond,
+		Waiting:        8 * time.Millisecond,
+		Receiving:      1 * time.Millisecond,
+	}
+
+	start := end.Add(-16 * time.Millisecond)
+	at := func(ms int) uint64 {
+		return uint64(start.Add(time.Duration(ms) * time.Millisecond).UnixNano())
+	}
+	assert.Equal(t, []*Phase{
+		{Name: "dns/blocked", StartTimeUnixNano: at(0), EndTimeUnixNano: at(1)},
+		{Name: "connect", StartTimeUnixNano: at(1), EndTimeUnixNano: at(3)},
+		{Name: "tls", StartTimeUnixNano: at(3), EndTimeUnixNano: at(6)},
+		{Name: "send", StartTimeUnixNano: at(6), EndTimeUnixNano: at(7)},
+		{Name: "ttfb", StartTimeUnixNano: at(7), EndTimeUnixNano: at(15)},
+		{Name: "receive", StartTimeUnixNano: at(15), EndTimeUnixNano: at(16)},
+	}, newPhases(trail))
+
+	trail.ConnDuration, trail.Connecting, trail.TLSHandshaking = 0, 0, 0
+	phases := newPhases(trail)
+	require.Len(t, phases, 4)
+	assert.Equal(t, "send", phases[1].Name)
+}
+
+func TestRedirectHopsBecomeLinkedSpans(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rt := newRedirectTracker()
+	hops := []*Request{
+		{TraceID: "abcdef", SpanID: "123456", HTTPStatus: 302},
+		{TraceID: "abcdef", SpanID: "123456", HTTPStatus: 307},
+		{TraceID: "abcdef", SpanID: "123456", HTTPStatus: 200},
+	}
+	for i, hop := range hops {
+		rt.track(hop, "call-1", now)
+		if i < len(hops)-1 {
+			assert.Len(t, rt.chains, 1)
+		}
+	}
+	assert.Empty(t, rt.chains, "the chain ends with the final response")
+
+	assert.Equal(t, "123456", hops[0].SpanID)
+	assert.Empty(t, hops[0].Links)
+	for i := 1; i < len(hops); i++ {
+		assert.NotEqual(t, hops[i-1].SpanID, hops[i].SpanID)
+		require.Len(t, hops[i].Links, 1)
+		assert.Equal(t, "abcdef", hops[i].Links[0].TraceID)
+		assert.Equal(t, hops[i-1].SpanID, hops[i].Links[0].SpanID)
+		assert.Equal(t, LinkTypeRedirect, hops[i].Links[0].Type)
+	}
+
+	// A redirect that isn't followed is forgotten after a while.
+	rt.track(&Request{TraceID: "abcdef", SpanID: "654321", HTTPStatus: 301}, "call-2", now)
+	rt.prune(now.Add(redirectChainTTL))
+	assert.Len(t, rt.chains, 1)
+	rt.prune(now.Add(2 * redirectChainTTL))
+	assert.Empty(t, rt.chains)
+}
+
+func TestRedirectTrackerKeepsNoStateForOtherResponses(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rt := newRedirectTracker()
+	for i := 0; i < 10; i++ {
+		rt.track(&Request{TraceID: "abcdef", SpanID: "123456", HTTPStatus: 200}, fmt.Sprintf("call-%d", i), now)
+	}
+	assert.Empty(t, rt.chains)
+
+	// The number of redirects waiting for their next hop is capped, by
+	// forgetting the oldest ones.
+	for i := 0; i < maxRedirectChains+1; i++ {
+		rt.track(&Request{TraceID: "abcdef", SpanID: "123456", HTTPStatus: 302},
+			fmt.Sprintf("call-%d", i), now.Add(time.Duration(i)*time.Millisecond))
+	}
+	assert.Len(t, rt.chains, maxRedirectChains)
+	assert.NotContains(t, rt.chains, "call-0")
+	assert.Contains(t, rt.chains, fmt.Sprintf("call-%d", maxRedirectChains))
+}
+
+func TestRedirectHopsAreGroupedByCall(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rt := newRedirectTracker()
+
+	// Two calls that sent the same preserved header aren't redirect hops.
+	first := &Request{TraceID: "abcdef", SpanID: "123456", HTTPStatus: 302}
+	second := &Request{TraceID: "abcdef", SpanID: "123456", HTTPStatus: 302}
+	rt.track(first, "call-1", now)
+	rt.track(second, "call-2", now)
+	assert.Equal(t, "123456", second.SpanID)
+	assert.Empty(t, second.Links)
+
+	// Without a call ID, the trace and span IDs are used.
+	hops := []*Request{{TraceID: "abcdef", SpanID: "654321", HTTPStatus: 302}, {TraceID: "abcdef", SpanID: "654321"}}
+	rt.track(hops[0], "", now)
+	rt.track(hops[1], "", now)
+	require.Len(t, hops[1].Links, 1)
+	assert.Equal(t, "654321", hops[1].Links[0].SpanID)
+}
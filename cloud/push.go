@@ -0,0 +1,154 @@
+package crocospans
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// pushBatch is an encoded batch of spans, waiting for a free sender.
+type pushBatch struct {
+	data  []byte
+	spans int
+}
+
+// encode encodes the requests into push batches, keeping track of the number
+// of spans in each of them.
+func (o *Output) encode(requests []*Request) ([]pushBatch, error) {
+	encoded, err := o.encoder.Encode(requests)
+	if err != nil {
+		return nil, err
+	}
+	counts := []int{len(requests)}
+	if sc, ok := o.encoder.(spanCounter); ok && len(encoded) > 1 {
+		counts = sc.spanCounts(requests)
+	}
+
+	batches := make([]pushBatch, len(encoded))
+	for i, data := range encoded {
+		batches[i].data = data
+		if i < len(counts) {
+			batches[i].spans = counts[i]
+		}
+	}
+	return batches, nil
+}
+
+// startPushers spins up the configured number of sender goroutines, which
+// consume marshaled batches from the push queue until it is closed.
+func (o *Output) startPushers() {
+	o.pushQueue = make(chan pushBatch, o.config.PushQueueSize)
+	for i := 0; i < o.config.PushConcurrency; i++ {
+		o.pushWG.Add(1)
+		go func() {
+			defer o.pushWG.Done()
+			for batch := range o.pushQueue {
+				if err := o.sendWithRetries(batch); err != nil {
+					o.logger.WithError(err).Error("Failed to send request metadata")
+				}
+			}
+		}()
+	}
+}
+
+// stopPushers closes the push queue and waits for the senders to drain it.
+func (o *Output) stopPushers() {
+	close(o.pushQueue)
+	o.pushWG.Wait()
+}
+
+// enqueue hands a marshaled batch over to the senders without blocking the
+// caller. If all senders are busy and the queue is full, the batch is dropped.
+func (o *Output) enqueue(batch pushBatch) {
+	select {
+	case o.pushQueue <- batch:
+	default:
+		o.logger.Warnf("Push queue is full, dropping a batch of %d bytes", len(batch.data))
+		o.stats.record(Stats{SpansDropped: int64(batch.spans)}, nil)
+	}
+}
+
+// sendWithRetries sends a batch, retrying transient failures with an
+// exponential backoff, and records the outcome in the output stats.
+func (o *Output) sendWithRetries(batch pushBatch) error {
+	delay := o.config.PushRetryDelay
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := o.send(batch.data)
+		delta := Stats{
+			PushDurations: []time.Duration{time.Since(start)},
+			BatchSizes:    []int{len(batch.data)},
+		}
+		if err == nil {
+			delta.SpansSent = int64(batch.spans)
+			o.stats.record(delta, nil)
+			return nil
+		}
+
+		delta.PushErrors = 1
+		var retryable *retryableError
+		if attempt >= o.config.PushRetries || !errors.As(err, &retryable) {
+			delta.SpansDropped = int64(batch.spans)
+			o.stats.record(delta, err)
+			return err
+		}
+		if attempt == 0 {
+			// The spans of a batch are counted once, however many times
+			// their push is retried.
+			delta.SpansRetried = int64(batch.spans)
+		}
+		o.stats.record(delta, err)
+
+		o.logger.WithError(err).Debugf("Retrying the push in %s", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// retryableError is a push failure that may succeed if it's retried, like a
+// network error or an overloaded backend.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// push sends a single marshaled batch to the configured endpoint.
+func (o *Output) push(batch []byte) error {
+	rq, err := http.NewRequest(http.MethodPost, o.config.Endpoint, bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", o.encoder.ContentType())
+	for key, val := range o.config.Headers {
+		rq.Header.Set(key, val)
+	}
+	if err := o.authenticate(rq); err != nil {
+		return err
+	}
+
+	res, err := o.httpClient.Do(rq)
+	if err != nil {
+		return &retryableError{err}
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= http.StatusBadRequest {
+		err := fmt.Errorf("unexpected response status %s", res.Status)
+		if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError {
+			return &retryableError{err}
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package crocospans
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// LinkTypeRedirect is the type of the link from a redirect hop to the
+// previous hop of the same request.
+const LinkTypeRedirect = "redirect"
+
+// redirectChainTTL is how long we wait for more hops of a redirect chain.
+const redirectChainTTL = time.Minute
+
+// maxRedirectChains bounds the redirect chains waiting for more hops, e.g.
+// when many redirects aren't followed. The oldest chain is forgotten first.
+const maxRedirectChains = 10000
+
+// redirectTracker turns the hops of a redirected request into separate spans.
+//
+// When k6 follows redirects, it emits one trail per hop and all of them carry
+// the same metadata, since they were made by a single traced call. The hops
+// are grouped by the call_id metadata of that call, or by their trace and span
+// IDs for trails without it, like the ones of older versions in replayed
+// files. The first hop keeps the original span ID, while the following ones
+// get derived span IDs and a link to the hop before them. A chain is only
+// kept while its last hop is a redirect response, so requests that aren't
+// redirected don't leave anything behind.
+//
+// It's only used from the flushing goroutine, so it's not safe for concurrent use.
+type redirectTracker struct {
+	chains map[string]*redirectChain
+}
+
+type redirectChain struct {
+	lastSpanID string
+	hops       int
+	lastSeen   time.Time
+}
+
+func newRedirectTracker() *redirectTracker {
+	return &redirectTracker{chains: make(map[string]*redirectChain)}
+}
+
+// track updates the span ID and links of the given request if it's a
+// subsequent hop of an already seen call.
+func (rt *redirectTracker) track(req *Request, callID string, now time.Time) {
+	if req.SpanID == "" {
+		return
+	}
+	key := callID
+	if key == "" {
+		key = req.TraceID + "-" + req.SpanID
+	}
+	chain, ok := rt.chains[key]
+	if ok {
+		chain.hops++
+		req.Links = append(req.Links, &Link{
+			TraceID: req.TraceID,
+			SpanID:  chain.lastSpanID,
+			Type:    LinkTypeRedirect,
+		})
+		req.SpanID = hopSpanID(req.SpanID, chain.hops)
+	}
+
+	if !isRedirectStatus(req.HTTPStatus) {
+		// It's the last hop of the call.
+		delete(rt.chains, key)
+		return
+	}
+	if !ok {
+		if len(rt.chains) >= maxRedirectChains {
+			rt.evictOldest()
+		}
+		chain = &redirectChain{}
+		rt.chains[key] = chain
+	}
+	chain.lastSpanID = req.SpanID
+	chain.lastSeen = now
+}
+
+func (rt *redirectTracker) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, chain := range rt.chains {
+		if oldestKey == "" || chain.lastSeen.Before(oldest) {
+			oldestKey, oldest = key, chain.lastSeen
+		}
+	}
+	delete(rt.chains, oldestKey)
+}
+
+// prune forgets the chains that haven't seen new hops in a while.
+func (rt *redirectTracker) prune(now time.Time) {
+	for key, chain := range rt.chains {
+		if now.Sub(chain.lastSeen) > redirectChainTTL {
+			delete(rt.chains, key)
+		}
+	}
+}
+
+// isRedirectStatus returns whether k6 may follow a response with the status
+// to another hop.
+func isRedirectStatus(status int64) bool {
+	switch status {
+	case 301, 302, 303, 307, 308:
+		return true
+	default:
+		return false
+	}
+}
+
+// hopSpanID deterministically derives the span ID of a redirect hop from the
+// span ID of the original request.
+func hopSpanID(spanID string, hop int) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%s/%d", spanID, hop)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
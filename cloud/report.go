@@ -0,0 +1,320 @@
+package crocospans
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// maxReportGroups bounds the scenario and URL pairs the report keeps the
+// slowest requests of, in case the URLs have unique IDs in them and the
+// requests aren't grouped with the name tag.
+const maxReportGroups = 1000
+
+// ReportConfig configures the end-of-test report of the slowest and failed
+// traced requests, which links the aggregated metrics of the k6 summary to
+// the traces behind them.
+type ReportConfig struct {
+	// Top is the number of the slowest requests kept per scenario and URL.
+	Top int
+	// Failed is the number of failed requests sampled from the whole test.
+	Failed int
+	// File is an optional .json or .html file the report is also written to.
+	File string
+}
+
+func (cfg ReportConfig) enabled() bool {
+	return cfg.Top > 0 || cfg.Failed > 0
+}
+
+// parseReportConfig parses the report options. The report is disabled unless
+// reportTop or reportFailed is set.
+func parseReportConfig(layers *configLayers) (ReportConfig, error) {
+	var cfg ReportConfig
+	if err := layers.int("reportTop", &cfg.Top); err != nil {
+		return cfg, err
+	}
+	if cfg.Top < 0 {
+		return cfg, layers.validationError("reportTop", "should not be negative but was %d", cfg.Top)
+	}
+	if err := layers.int("reportFailed", &cfg.Failed); err != nil {
+		return cfg, err
+	}
+	if cfg.Failed < 0 {
+		return cfg, layers.validationError("reportFailed", "should not be negative but was %d", cfg.Failed)
+	}
+
+	layers.string("reportFile", &cfg.File)
+	if cfg.File == "" {
+		return cfg, nil
+	}
+	if !cfg.enabled() {
+		return cfg, layers.validationError("reportFile", "needs reportTop or reportFailed to be set")
+	}
+	switch strings.ToLower(filepath.Ext(cfg.File)) {
+	case ".json", ".html", ".htm":
+	default:
+		return cfg, layers.validationError("reportFile", "should be a .json or .html file but was '%s'", cfg.File)
+	}
+	return cfg, nil
+}
+
+// ReportEntry is a traced request in the report.
+type ReportEntry struct {
+	TraceID  string        `json:"trace_id"`
+	SpanID   string        `json:"span_id"`
+	Scenario string        `json:"scenario"`
+	URL      string        `json:"url"`
+	Method   string        `json:"method"`
+	Status   int64         `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"-"`
+	// DurationMs is Duration in milliseconds, for the JSON report.
+	DurationMs float64 `json:"duration_ms"`
+	// Sampled tells whether the span was kept by the sampling, i.e. whether
+	// the trace can be found in the backend.
+	Sampled bool `json:"sampled"`
+}
+
+// ReportGroup are the slowest requests of a scenario and URL, the slowest
+// first.
+type ReportGroup struct {
+	Scenario string        `json:"scenario"`
+	URL      string        `json:"url"`
+	Requests []ReportEntry `json:"requests"`
+}
+
+// Report is the end-of-test report of the slowest and failed traced requests.
+type Report struct {
+	TestRunID string        `json:"test_run_id"`
+	Slowest   []ReportGroup `json:"slowest"`
+	Failed    []ReportEntry `json:"failed"`
+	// FailedTotal is the number of failed requests Failed was sampled from.
+	FailedTotal int64 `json:"failed_total"`
+	// DroppedGroups is the number of requests that weren't considered for
+	// Slowest, because there were too many scenario and URL pairs.
+	DroppedGroups int64 `json:"dropped_groups,omitempty"`
+}
+
+// entryHeap is a min-heap of requests by duration, so the fastest of the
+// kept requests is the one replaced by a slower one.
+type entryHeap []ReportEntry
+
+func (h entryHeap) Len() int            { return len(h) }
+func (h entryHeap) Less(i, j int) bool  { return h[i].Duration < h[j].Duration }
+func (h entryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *entryHeap) Push(x interface{}) { *h = append(*h, x.(ReportEntry)) }
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type reportKey struct {
+	scenario, url string
+}
+
+// reportCollector keeps the slowest requests of every scenario and URL in
+// bounded heaps, and a reservoir sample of the failed ones, so its memory
+// doesn't grow with the length of the test.
+type reportCollector struct {
+	cfg ReportConfig
+
+	mu            sync.Mutex
+	slowest       map[reportKey]*entryHeap
+	droppedGroups int64
+	failed        []ReportEntry
+	failedTotal   int64
+	rand          *rand.Rand
+}
+
+func newReportCollector(cfg ReportConfig) *reportCollector {
+	return &reportCollector{
+		cfg:     cfg,
+		slowest: make(map[reportKey]*entryHeap),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())), //nolint:gosec
+	}
+}
+
+// record adds a traced request, before it's sampled, with its k6 duration,
+// i.e. the one of http_req_duration.
+func (c *reportCollector) record(req *Request, duration time.Duration, sampled bool) {
+	url := req.Name
+	if url == "" {
+		url = req.HTTPUrl
+	}
+	entry := ReportEntry{
+		TraceID:    req.TraceID,
+		SpanID:     req.SpanID,
+		Scenario:   req.Scenario,
+		URL:        url,
+		Method:     req.HTTPMethod,
+		Status:     req.HTTPStatus,
+		Error:      req.Error,
+		Duration:   duration,
+		DurationMs: float64(duration) / float64(time.Millisecond),
+		Sampled:    sampled,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg.Top > 0 {
+		c.addSlowest(reportKey{scenario: req.Scenario, url: url}, entry)
+	}
+	if c.cfg.Failed > 0 && (!req.ExpectedResponse || req.Error != "" || req.ErrorCode != 0) {
+		c.addFailed(entry)
+	}
+}
+
+func (c *reportCollector) addSlowest(key reportKey, entry ReportEntry) {
+	h, ok := c.slowest[key]
+	if !ok {
+		if len(c.slowest) >= maxReportGroups {
+			c.droppedGroups++
+			return
+		}
+		h = &entryHeap{}
+		c.slowest[key] = h
+	}
+	if h.Len() < c.cfg.Top {
+		heap.Push(h, entry)
+		return
+	}
+	if entry.Duration > (*h)[0].Duration {
+		(*h)[0] = entry
+		heap.Fix(h, 0)
+	}
+}
+
+// addFailed keeps a uniform sample of the failed requests, with reservoir
+// sampling, instead of only the first ones of the test.
+func (c *reportCollector) addFailed(entry ReportEntry) {
+	c.failedTotal++
+	if len(c.failed) < c.cfg.Failed {
+		c.failed = append(c.failed, entry)
+		return
+	}
+	if i := c.rand.Int63n(c.failedTotal); i < int64(c.cfg.Failed) {
+		c.failed[i] = entry
+	}
+}
+
+// report returns the collected requests, with the groups sorted by their
+// slowest request, and the failed requests the slowest first.
+func (c *reportCollector) report(testRunID string) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := Report{TestRunID: testRunID, FailedTotal: c.failedTotal, DroppedGroups: c.droppedGroups}
+	for key, h := range c.slowest {
+		requests := append([]ReportEntry(nil), *h...)
+		sort.Slice(requests, func(i, j int) bool { return requests[i].Duration > requests[j].Duration })
+		r.Slowest = append(r.Slowest, ReportGroup{Scenario: key.scenario, URL: key.url, Requests: requests})
+	}
+	sort.Slice(r.Slowest, func(i, j int) bool {
+		a, b := r.Slowest[i], r.Slowest[j]
+		if a.Requests[0].Duration != b.Requests[0].Duration {
+			return a.Requests[0].Duration > b.Requests[0].Duration
+		}
+		return a.Scenario+a.URL < b.Scenario+b.URL
+	})
+	r.Failed = append(r.Failed, c.failed...)
+	sort.Slice(r.Failed, func(i, j int) bool { return r.Failed[i].Duration > r.Failed[j].Duration })
+	return r
+}
+
+// writeReportTable writes the report as plain text tables.
+func writeReportTable(w io.Writer, r Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(e ReportEntry) {
+		sampled := "yes"
+		if !e.Sampled {
+			sampled = "no"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			e.Scenario, e.Method, e.URL, e.Status, e.Duration.Round(time.Microsecond), e.TraceID, sampled)
+	}
+	header := "SCENARIO\tMETHOD\tURL\tSTATUS\tDURATION\tTRACE ID\tSAMPLED\n"
+
+	if len(r.Slowest) > 0 {
+		fmt.Fprintf(tw, "Slowest traced requests\n\n%s", header)
+		for _, g := range r.Slowest {
+			for _, e := range g.Requests {
+				row(e)
+			}
+		}
+	}
+	if r.FailedTotal > 0 {
+		if len(r.Slowest) > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "Failed traced requests (%d of %d)\n\n%s", len(r.Failed), r.FailedTotal, header)
+		for _, e := range r.Failed {
+			row(e)
+		}
+	}
+	return tw.Flush()
+}
+
+var reportHTMLTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Traced requests of {{.TestRunID}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.num { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Traced requests of {{.TestRunID}}</h1>
+{{define "header"}}<tr><th>Scenario</th><th>Method</th><th>URL</th><th>Status</th><th>Duration</th><th>Trace ID</th><th>Span ID</th><th>Sampled</th><th>Error</th></tr>{{end}}
+{{define "row"}}<tr><td>{{.Scenario}}</td><td>{{.Method}}</td><td>{{.URL}}</td><td class="num">{{.Status}}</td><td class="num">{{.Duration}}</td><td><code>{{.TraceID}}</code></td><td><code>{{.SpanID}}</code></td><td>{{if .Sampled}}yes{{else}}no{{end}}</td><td>{{.Error}}</td></tr>{{end}}
+{{if .Slowest}}<h2>Slowest traced requests</h2>
+<table>
+{{template "header"}}
+{{range .Slowest}}{{range .Requests}}{{template "row" .}}
+{{end}}{{end}}</table>
+{{end}}{{if .FailedTotal}}<h2>Failed traced requests ({{len .Failed}} of {{.FailedTotal}})</h2>
+<table>
+{{template "header"}}
+{{range .Failed}}{{template "row" .}}
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// writeReportFile writes the report to a JSON or HTML file, depending on its
+// extension.
+func writeReportFile(fs afero.Fs, path string, r Report) (err error) {
+	f, err := fs.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	return reportHTMLTemplate.Execute(f, r)
+}
@@ -0,0 +1,171 @@
+package crocospans
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.k6.io/k6/lib/testutils"
+	"go.k6.io/k6/metrics"
+	"go.k6.io/k6/output"
+)
+
+func TestReportCollectorKeepsSlowestAndFailed(t *testing.T) {
+	t.Parallel()
+
+	c := newReportCollector(ReportConfig{Top: 2, Failed: 3})
+	for i := 1; i <= 5; i++ {
+		for _, url := range []string{"http://a", "http://b"} {
+			c.record(&Request{
+				TraceID:          fmt.Sprintf("%s-%d", url, i),
+				Scenario:         "default",
+				HTTPUrl:          url,
+				HTTPStatus:       200,
+				ExpectedResponse: true,
+			}, time.Duration(i)*time.Millisecond, true)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		c.record(&Request{
+			TraceID:    fmt.Sprintf("failed-%d", i),
+			Scenario:   "default",
+			HTTPUrl:    "http://c",
+			HTTPStatus: 500,
+		}, time.Microsecond, false)
+	}
+
+	r := c.report("run")
+	require.Len(t, r.Slowest, 3)
+	for _, g := range r.Slowest[:2] {
+		require.Len(t, g.Requests, 2)
+		assert.Equal(t, g.URL+"-5", g.Requests[0].TraceID)
+		assert.Equal(t, g.URL+"-4", g.Requests[1].TraceID)
+		assert.Equal(t, 5.0, g.Requests[0].DurationMs)
+	}
+	assert.Equal(t, "http://c", r.Slowest[2].URL)
+	assert.Len(t, r.Failed, 3)
+	assert.Equal(t, int64(10), r.FailedTotal)
+	for _, e := range r.Failed {
+		assert.Equal(t, int64(500), e.Status)
+		assert.False(t, e.Sampled)
+	}
+}
+
+func TestReportGroupsByName(t *testing.T) {
+	t.Parallel()
+
+	c := newReportCollector(ReportConfig{Top: 1})
+	for i := 0; i < 3; i++ {
+		c.record(&Request{
+			TraceID:  fmt.Sprintf("trace-%d", i),
+			Scenario: "default",
+			HTTPUrl:  fmt.Sprintf("http://example.com/items/%d", i),
+			Name:     "http://example.com/items/${id}",
+		}, time.Duration(i)*time.Millisecond, true)
+	}
+
+	r := c.report("run")
+	require.Len(t, r.Slowest, 1)
+	assert.Equal(t, "http://example.com/items/${id}", r.Slowest[0].URL)
+	assert.Equal(t, "trace-2", r.Slowest[0].Requests[0].TraceID)
+}
+
+func TestOutputWritesReport(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	stderr := &bytes.Buffer{}
+	o, err := NewFile(output.Params{
+		ConfigArgument: "spans.ndjson",
+		FS:             fs,
+		StdErr:         stderr,
+		Logger:         testutils.NewLogger(t),
+		Environment: map[string]string{
+			"XK6_SPANS_FILE_PUSH_INTERVAL": "1h",
+			"XK6_SPANS_FILE_SAMPLE_RATE":   "0",
+			"XK6_SPANS_FILE_REPORT_TOP":    "1",
+			"XK6_SPANS_FILE_REPORT_FAILED": "5",
+			"XK6_SPANS_FILE_REPORT_FILE":   "report.json",
+		},
+	})
+	require.NoError(t, err)
+
+	ok, failed := newTestTrail("aaaaaa"), newTestTrail("bbbbbb")
+	failed.Duration = 20 * time.Millisecond
+	failed.Tags = failed.Tags.With("status", "500").With("expected_response", "false")
+
+	require.NoError(t, o.Start())
+	o.AddMetricSamples([]metrics.SampleContainer{ok, failed})
+	require.NoError(t, o.Stop())
+
+	table := stderr.String()
+	assert.Contains(t, table, "Slowest traced requests")
+	assert.Contains(t, table, "Failed traced requests (1 of 1)")
+	assert.Contains(t, table, "bbbbbb")
+	assert.NotContains(t, table, "aaaaaa")
+
+	data, err := afero.ReadFile(fs, "report.json")
+	require.NoError(t, err)
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Len(t, report.Slowest, 1)
+	assert.Equal(t, "bbbbbb", report.Slowest[0].Requests[0].TraceID)
+	assert.Equal(t, 20.0, report.Slowest[0].Requests[0].DurationMs)
+	// Only the failed request was kept by the sampling.
+	assert.True(t, report.Slowest[0].Requests[0].Sampled)
+	require.Len(t, report.Failed, 1)
+	assert.Equal(t, int64(500), report.Failed[0].Status)
+}
+
+func TestOutputWritesHTMLReport(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	o, err := NewFile(output.Params{
+		ConfigArgument: "spans.ndjson",
+		FS:             fs,
+		StdErr:         &bytes.Buffer{},
+		Logger:         testutils.NewLogger(t),
+		Environment: map[string]string{
+			"XK6_SPANS_FILE_PUSH_INTERVAL": "1h",
+			"XK6_SPANS_FILE_REPORT_TOP":    "3",
+			"XK6_SPANS_FILE_REPORT_FILE":   "report.html",
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, o.Start())
+	o.AddMetricSamples([]metrics.SampleContainer{newTestTrail("<abcdef>")})
+	require.NoError(t, o.Stop())
+
+	data, err := afero.ReadFile(fs, "report.html")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "<code>&lt;abcdef&gt;</code>")
+}
+
+func TestReportConfigErrors(t *testing.T) {
+	t.Parallel()
+
+	for msg, env := range map[string]map[string]string{
+		"should not be negative": {"XK6_SPANS_FILE_REPORT_TOP": "-1"},
+		"needs reportTop":        {"XK6_SPANS_FILE_REPORT_FILE": "report.json"},
+		"should be a .json or .html file": {
+			"XK6_SPANS_FILE_REPORT_TOP":  "1",
+			"XK6_SPANS_FILE_REPORT_FILE": "report.txt",
+		},
+	} {
+		_, err := NewFile(output.Params{
+			ConfigArgument: "spans.ndjson",
+			FS:             afero.NewMemMapFs(),
+			Logger:         testutils.NewLogger(t),
+			Environment:    env,
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), msg)
+	}
+}
@@ -0,0 +1,196 @@
+package crocospans
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.k6.io/k6/lib/netext/httpext"
+	"go.k6.io/k6/metrics"
+)
+
+// newRequest converts a traced HTTP trail into a Request span. The trace_id
+// metadata has to be present on the trail.
+func newRequest(trail *httpext.Trail, testRunID string) (*Request, error) {
+	// Depending on the k6 system tags that are enabled, some of the values
+	// may be either indexed tags or unindexed metadata.
+	get := func(name string) string {
+		if val, ok := trail.Tags.Get(name); ok {
+			return val
+		}
+		return trail.Metadata[name]
+	}
+	getInt := func(name string) int64 {
+		val, _ := strconv.ParseInt(get(name), 10, 64)
+		return val
+	}
+
+	strStatus := get("status")
+	status, err := strconv.ParseInt(strStatus, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error parsing status '%s': %w", strStatus, err)
+	}
+
+	req := &Request{
+		StartTimeUnixNano: uint64(trailStartTime(trail).UnixNano()),
+		EndTimeUnixNano:   uint64(trail.EndTime.UnixNano()),
+		Group:             get("group"),
+		Scenario:          get("scenario"),
+		TraceID:           trail.Metadata["trace_id"],
+		SpanID:            trail.Metadata["span_id"],
+		ParentSpanID:      trail.Metadata["parent_span_id"],
+		HTTPUrl:           get("url"),
+		HTTPMethod:        get("method"),
+		HTTPStatus:        status,
+		VUID:              getInt("vu"),
+		Iteration:         getInt("iter"),
+		ExpectedResponse:  get("expected_response") != "false",
+		Error:             get("error"),
+		ErrorCode:         getInt("error_code"),
+		Proto:             get("proto"),
+		TLSVersion:        get("tls_version"),
+		Name:              get("name"),
+		RequestBytes:      getInt("request_bytes"),
+		ResponseBytes:     getInt("response_bytes"),
+	}
+
+	// Everything that isn't a k6 system tag was set by the user.
+	for name, val := range trail.Tags.Map() {
+		if _, err := metrics.SystemTagString(name); err == nil {
+			continue
+		}
+		if req.Tags == nil {
+			req.Tags = make(map[string]string)
+		}
+		req.Tags[name] = val
+	}
+	req.setTestRunID(testRunID)
+	if link := serverLink(trail.Metadata); link != nil {
+		req.Links = append(req.Links, link)
+	}
+
+	return req, nil
+}
+
+// setTestRunID sets the test run ID of a request. The numeric field, which is
+// the one the crocospans backend reads, is only set for numeric IDs, like the
+// ones of k6 cloud runs.
+func (req *Request) setTestRunID(testRunID string) {
+	req.TestRunIDString = testRunID
+	req.TestRunID, _ = strconv.ParseInt(testRunID, 10, 64)
+}
+
+// MetricWSMessages is the metric of the samples emitted for the traced
+// WebSocket messages, which carry the span of each message in their metadata.
+const MetricWSMessages = "tracing_ws_messages"
+
+// MetricInjectedSpans is the metric of the samples emitted for the spans
+// recorded by tracing.inject(), e.g. the ones of produced Kafka messages.
+const MetricInjectedSpans = "tracing_injected_spans"
+
+// isSpanSample returns whether a sample, which isn't an HTTP trail, is turned
+// into a span: a traced WebSocket session or message, or an injected span.
+func isSpanSample(sample metrics.Sample) bool {
+	if _, hasTrace := sample.Metadata["trace_id"]; !hasTrace {
+		return false
+	}
+	switch sample.Metric.Name {
+	case metrics.WSSessionDurationName, MetricWSMessages, MetricInjectedSpans:
+		return true
+	}
+	return false
+}
+
+// newSampleRequest converts a traced WebSocket session or message sample, or
+// an injected span sample, into a Request span. The session span covers the
+// whole connection, while the message and injected spans are instantaneous.
+func newSampleRequest(sample metrics.Sample, testRunID string) *Request {
+	get := func(name string) string {
+		if val, ok := sample.Tags.Get(name); ok {
+			return val
+		}
+		return sample.Metadata[name]
+	}
+	getInt := func(name string) int64 {
+		val, _ := strconv.ParseInt(get(name), 10, 64)
+		return val
+	}
+
+	start := sample.Time
+	end := start
+	req := &Request{
+		Group:            get("group"),
+		Scenario:         get("scenario"),
+		TraceID:          sample.Metadata["trace_id"],
+		SpanID:           sample.Metadata["span_id"],
+		ParentSpanID:     sample.Metadata["parent_span_id"],
+		HTTPUrl:          get("url"),
+		HTTPStatus:       getInt("status"),
+		VUID:             getInt("vu"),
+		Iteration:        getInt("iter"),
+		ExpectedResponse: true,
+	}
+	switch sample.Metric.Name {
+	case metrics.WSSessionDurationName:
+		end = start.Add(time.Duration(sample.Value * float64(time.Millisecond)))
+		req.HTTPMethod = "GET"
+		req.Name = "ws session"
+		req.ExpectedResponse = req.HTTPStatus == 0 || req.HTTPStatus == 101
+	case MetricInjectedSpans:
+		req.HTTPMethod = "INJECT"
+		req.Name = sample.Metadata["name"]
+	default:
+		req.HTTPMethod = "SEND"
+		req.Name = "ws message"
+	}
+	req.StartTimeUnixNano = uint64(start.UnixNano())
+	req.EndTimeUnixNano = uint64(end.UnixNano())
+	req.setTestRunID(testRunID)
+	return req
+}
+
+// trailStartTime reconstructs the time at which k6 started the request. The
+// connection time doesn't need to be added separately, since it's a part of
+// the time k6 was blocked waiting for a connection.
+func trailStartTime(trail *httpext.Trail) time.Time {
+	return trail.EndTime.Add(-(trail.Blocked + trail.Duration))
+}
+
+// newPhases splits the request span into its consecutive timing phases, as
+// measured by k6. Phases that didn't happen, e.g. connecting when a connection
+// was reused, are omitted.
+func newPhases(trail *httpext.Trail) []*Phase {
+	// Blocked spans the whole time until k6 got a connection, so it already
+	// includes connecting and the TLS handshake for new connections.
+	blocked := trail.Blocked - trail.ConnDuration
+	if blocked < 0 {
+		blocked = 0
+	}
+	phases := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"dns/blocked", blocked},
+		{"connect", trail.Connecting},
+		{"tls", trail.TLSHandshaking},
+		{"send", trail.Sending},
+		{"ttfb", trail.Waiting},
+		{"receive", trail.Receiving},
+	}
+
+	start := trailStartTime(trail)
+	result := make([]*Phase, 0, len(phases))
+	for _, p := range phases {
+		if p.duration <= 0 {
+			continue
+		}
+		end := start.Add(p.duration)
+		result = append(result, &Phase{
+			Name:              p.name,
+			StartTimeUnixNano: uint64(start.UnixNano()),
+			EndTimeUnixNano:   uint64(end.UnixNano()),
+		})
+		start = end
+	}
+	return result
+}
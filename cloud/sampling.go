@@ -0,0 +1,132 @@
+package crocospans
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SamplingConfig configures which traced requests the outputs keep. Since the
+// outputs see the final status and duration of every request, they can make
+// tail sampling decisions.
+type SamplingConfig struct {
+	// Rate is the ratio of the requests that are kept, unless an error or
+	// the duration forces it or a rule overrides it.
+	Rate float64
+	// KeepErrors keeps all failed requests and the ones with an unexpected
+	// response.
+	KeepErrors bool
+	// SlowerThan keeps all requests that took at least as long, if set.
+	SlowerThan time.Duration
+	// Rules override the rate for specific scenarios or URLs. The first
+	// matching rule wins.
+	Rules []SamplingRule
+}
+
+// SamplingRule overrides the sampling rate of the requests of a scenario, or
+// of the requests with a URL matching a pattern, where * matches anything.
+type SamplingRule struct {
+	Scenario   string
+	URLPattern string
+	Rate       float64
+
+	urlRegexp *regexp.Regexp
+}
+
+func (r SamplingRule) matches(req *Request) bool {
+	if r.urlRegexp != nil {
+		return r.urlRegexp.MatchString(req.HTTPUrl)
+	}
+	return r.Scenario == req.Scenario
+}
+
+// parseSamplingConfig parses the sampling options. The rules are set in the
+// 'scenario:checkout=100%,url:*/health=0' format.
+func parseSamplingConfig(layers *configLayers) (SamplingConfig, error) {
+	cfg := SamplingConfig{Rate: 1, KeepErrors: true}
+	if err := layers.ratio("sampleRate", &cfg.Rate); err != nil {
+		return cfg, err
+	}
+	if err := layers.bool("sampleKeepErrors", &cfg.KeepErrors); err != nil {
+		return cfg, err
+	}
+	if err := layers.duration("sampleSlowerThan", &cfg.SlowerThan); err != nil {
+		return cfg, err
+	}
+
+	val, ok := layers.get("sampleRules")
+	if !ok || strings.TrimSpace(val) == "" {
+		return cfg, nil
+	}
+	for _, def := range strings.Split(val, ",") {
+		i := strings.LastIndexByte(def, '=')
+		if i < 0 {
+			return cfg, layers.invalid("sampleRules", val, fmt.Errorf("expected 'scenario:name=rate' or 'url:pattern=rate' rules"))
+		}
+		rate, err := parseRatio(def[i+1:])
+		if err != nil {
+			return cfg, layers.invalid("sampleRules", val, err)
+		}
+
+		rule := SamplingRule{Rate: rate}
+		match := strings.TrimSpace(def[:i])
+		switch {
+		case strings.HasPrefix(match, "scenario:"):
+			rule.Scenario = strings.TrimPrefix(match, "scenario:")
+		case strings.HasPrefix(match, "url:"):
+			rule.URLPattern = strings.TrimPrefix(match, "url:")
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(rule.URLPattern), `\*`, ".*")
+			rule.urlRegexp = regexp.MustCompile("^" + pattern + "$")
+		default:
+			return cfg, layers.invalid("sampleRules", val, fmt.Errorf("unknown rule '%s', expected a 'scenario:' or 'url:' prefix", match))
+		}
+		cfg.Rules = append(cfg.Rules, rule)
+	}
+	return cfg, nil
+}
+
+// keep decides whether a traced request is sent. The rate-based decision is
+// made on the trace ID, so the spans of a trace, like the hops of a redirect
+// chain, get the same one, and all outputs agree on it. The errors and slow
+// requests are kept per span though, so a trace can be sent partially, with
+// only the failed hop of a redirect chain in it.
+func (cfg SamplingConfig) keep(req *Request) bool {
+	if cfg.KeepErrors && (!req.ExpectedResponse || req.Error != "" || req.ErrorCode != 0) {
+		return true
+	}
+	if cfg.SlowerThan > 0 && time.Duration(req.EndTimeUnixNano-req.StartTimeUnixNano) >= cfg.SlowerThan {
+		return true
+	}
+
+	rate := cfg.Rate
+	for _, rule := range cfg.Rules {
+		if rule.matches(req) {
+			rate = rule.Rate
+			break
+		}
+	}
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(req.TraceID))
+	return float64(mix64(h.Sum64()))/math.MaxUint64 < rate
+}
+
+// mix64 is the splitmix64 finalizer. The FNV hash alone doesn't spread trace
+// IDs that differ only in their last characters over the whole range.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
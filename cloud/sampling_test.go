@@ -0,0 +1,152 @@
+package crocospans
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.k6.io/k6/output"
+)
+
+func newTestSamplingConfig(t *testing.T, env map[string]string) SamplingConfig {
+	t.Helper()
+
+	cfg, _, err := newConfig(output.Params{ConfigArgument: "http://localhost", Environment: env}, outputSpec{
+		name:      "xk6-test",
+		envPrefix: "XK6_TEST_",
+	})
+	require.NoError(t, err)
+	return cfg.Sampling
+}
+
+func TestSamplingKeepsErrorsAndSlowRequests(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestSamplingConfig(t, map[string]string{
+		"XK6_TEST_SAMPLE_RATE":        "0",
+		"XK6_TEST_SAMPLE_SLOWER_THAN": "1s",
+	})
+
+	start := uint64(time.Now().UnixNano())
+	req := func(expected bool, errorCode int64, duration time.Duration) *Request {
+		return &Request{
+			TraceID:           "abcdef",
+			ExpectedResponse:  expected,
+			ErrorCode:         errorCode,
+			StartTimeUnixNano: start,
+			EndTimeUnixNano:   start + uint64(duration),
+		}
+	}
+	assert.False(t, cfg.keep(req(true, 0, time.Millisecond)))
+	assert.True(t, cfg.keep(req(false, 0, time.Millisecond)))
+	assert.True(t, cfg.keep(req(true, 1000, time.Millisecond)))
+	assert.True(t, cfg.keep(req(true, 0, 2*time.Second)))
+
+	cfg.KeepErrors = false
+	assert.False(t, cfg.keep(req(false, 1000, time.Millisecond)))
+}
+
+func TestSamplingRateAndRules(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestSamplingConfig(t, map[string]string{
+		"XK6_TEST_SAMPLE_RATE":  "25%",
+		"XK6_TEST_SAMPLE_RULES": "scenario:checkout=1, url:*/health=0,url:https://*.k6.io/api/*=0.5",
+	})
+	require.Len(t, cfg.Rules, 3)
+	assert.Equal(t, "checkout", cfg.Rules[0].Scenario)
+	assert.Equal(t, "*/health", cfg.Rules[1].URLPattern)
+
+	kept := func(scenario, url string) int {
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if cfg.keep(&Request{TraceID: fmt.Sprintf("%032x", i), ExpectedResponse: true, Scenario: scenario, HTTPUrl: url}) {
+				n++
+			}
+		}
+		return n
+	}
+	assert.InDelta(t, 250, kept("default", "https://test.k6.io/"), 50)
+	assert.Equal(t, 1000, kept("checkout", "https://test.k6.io/health"))
+	assert.Equal(t, 0, kept("default", "https://test.k6.io/health"))
+	assert.InDelta(t, 500, kept("default", "https://test-api.k6.io/api/crocodiles"), 50)
+
+}
+
+func TestSamplingRateIsPerTrace(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestSamplingConfig(t, map[string]string{"XK6_TEST_SAMPLE_RATE": "50%"})
+	hop := func(traceID, url string, status int64) *Request {
+		return &Request{TraceID: traceID, HTTPUrl: url, HTTPStatus: status, ExpectedResponse: true}
+	}
+
+	kept, dropped := 0, 0
+	for i := 0; i < 100; i++ {
+		traceID := fmt.Sprintf("%032x", i)
+		redirect := cfg.keep(hop(traceID, "https://test.k6.io/redirect", 302))
+		final := cfg.keep(hop(traceID, "https://test.k6.io/final", 200))
+		assert.Equal(t, redirect, final, traceID)
+		if final {
+			kept++
+		} else {
+			dropped++
+		}
+	}
+	// Both decisions were actually taken, so the spans didn't agree by chance.
+	assert.Greater(t, kept, 0)
+	assert.Greater(t, dropped, 0)
+}
+
+func TestSamplingKeepsErrorsPerSpan(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestSamplingConfig(t, map[string]string{
+		"XK6_TEST_SAMPLE_RATE":        "0",
+		"XK6_TEST_SAMPLE_SLOWER_THAN": "1s",
+	})
+
+	// Only the failed or slow hop of a redirect chain is kept, not the whole
+	// trace.
+	start := uint64(time.Now().UnixNano())
+	redirect := &Request{TraceID: "abcdef", HTTPStatus: 302, ExpectedResponse: true,
+		StartTimeUnixNano: start, EndTimeUnixNano: start + uint64(time.Millisecond)}
+	failed := &Request{TraceID: "abcdef", HTTPStatus: 500, ExpectedResponse: false,
+		StartTimeUnixNano: start, EndTimeUnixNano: start + uint64(time.Millisecond)}
+	slow := &Request{TraceID: "abcdef", HTTPStatus: 200, ExpectedResponse: true,
+		StartTimeUnixNano: start, EndTimeUnixNano: start + uint64(2*time.Second)}
+	assert.False(t, cfg.keep(redirect))
+	assert.True(t, cfg.keep(failed))
+	assert.True(t, cfg.keep(slow))
+}
+
+func TestSamplingConfigErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		env map[string]string
+		err string
+	}{
+		{
+			env: map[string]string{"XK6_TEST_SAMPLE_RATE": "150%"},
+			err: "invalid sampleRate '150%'",
+		},
+		{
+			env: map[string]string{"XK6_TEST_SAMPLE_RULES": "checkout=1"},
+			err: "unknown rule 'checkout', expected a 'scenario:' or 'url:' prefix",
+		},
+		{
+			env: map[string]string{"XK6_TEST_SAMPLE_RULES": "scenario:checkout"},
+			err: "expected 'scenario:name=rate' or 'url:pattern=rate' rules",
+		},
+	}
+	for _, tt := range tests {
+		_, _, err := newConfig(output.Params{ConfigArgument: "http://localhost", Environment: tt.env},
+			outputSpec{name: "xk6-test", envPrefix: "XK6_TEST_"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tt.err)
+	}
+}
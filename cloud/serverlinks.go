@@ -0,0 +1,17 @@
+package crocospans
+
+// LinkTypeServer is the type of the link from a k6 span to the span the
+// server reported having recorded, e.g. in a traceresponse header, when it
+// restarted or re-sampled the trace.
+const LinkTypeServer = "server"
+
+// serverLink returns the link to the server's span, which the tracing client
+// adds to the metadata of the last trail of a request, i.e. the one of the
+// response the server trace context was taken from.
+func serverLink(metadata map[string]string) *Link {
+	traceID, spanID := metadata["link_trace_id"], metadata["link_span_id"]
+	if traceID == "" || spanID == "" {
+		return nil
+	}
+	return &Link{TraceID: traceID, SpanID: spanID, Type: LinkTypeServer}
+}
@@ -0,0 +1,108 @@
+package crocospans
+
+import (
+	"sync"
+	"time"
+)
+
+// maxPendingObservations bounds the push durations and batch sizes kept until
+// the next DrainStats call, in case nothing drains them.
+const maxPendingObservations = 10000
+
+// Stats are the self-observability measurements of the span outputs.
+type Stats struct {
+	// SpansBuffered is the number of traced requests taken from the k6
+	// samples, before they are encoded and queued.
+	SpansBuffered int64
+	// SpansSent is the number of spans successfully pushed to the backend.
+	SpansSent int64
+	// SpansDropped is the number of spans that never reached the backend,
+	// because the push queue was full or the push failed.
+	SpansDropped int64
+	// SpansRetried is the number of spans whose push was retried, at least
+	// once.
+	SpansRetried int64
+	// PushErrors is the number of failed push attempts.
+	PushErrors int64
+
+	PushDurations []time.Duration
+	BatchSizes    []int
+}
+
+func (s *Stats) add(other Stats) {
+	s.SpansBuffered += other.SpansBuffered
+	s.SpansSent += other.SpansSent
+	s.SpansDropped += other.SpansDropped
+	s.SpansRetried += other.SpansRetried
+	s.PushErrors += other.PushErrors
+	if room := maxPendingObservations - len(s.PushDurations); room > 0 {
+		if len(other.PushDurations) > room {
+			other.PushDurations = other.PushDurations[:room]
+		}
+		s.PushDurations = append(s.PushDurations, other.PushDurations...)
+	}
+	if room := maxPendingObservations - len(s.BatchSizes); room > 0 {
+		if len(other.BatchSizes) > room {
+			other.BatchSizes = other.BatchSizes[:room]
+		}
+		s.BatchSizes = append(s.BatchSizes, other.BatchSizes...)
+	}
+}
+
+// since returns the counters that were added since the prev totals.
+func (s Stats) since(prev Stats) Stats {
+	return Stats{
+		SpansBuffered: s.SpansBuffered - prev.SpansBuffered,
+		SpansSent:     s.SpansSent - prev.SpansSent,
+		SpansDropped:  s.SpansDropped - prev.SpansDropped,
+		SpansRetried:  s.SpansRetried - prev.SpansRetried,
+		PushErrors:    s.PushErrors - prev.PushErrors,
+	}
+}
+
+var pendingStats = struct {
+	sync.Mutex
+	Stats
+}{}
+
+// DrainStats returns the measurements of all span outputs since the previous
+// call. The tracing JS module uses them to emit its k6 metrics, since outputs
+// can't emit metric samples themselves.
+func DrainStats() Stats {
+	pendingStats.Lock()
+	defer pendingStats.Unlock()
+	stats := pendingStats.Stats
+	pendingStats.Stats = Stats{}
+	return stats
+}
+
+// outputStats keeps the totals of a single output for its summary log, and
+// forwards every measurement to the pending stats.
+type outputStats struct {
+	mu        sync.Mutex
+	totals    Stats
+	lastError error
+}
+
+func (s *outputStats) record(delta Stats, err error) {
+	s.mu.Lock()
+	s.totals.SpansBuffered += delta.SpansBuffered
+	s.totals.SpansSent += delta.SpansSent
+	s.totals.SpansDropped += delta.SpansDropped
+	s.totals.SpansRetried += delta.SpansRetried
+	s.totals.PushErrors += delta.PushErrors
+	if err != nil {
+		s.lastError = err
+	}
+	s.mu.Unlock()
+
+	pendingStats.Lock()
+	pendingStats.add(delta)
+	pendingStats.Unlock()
+}
+
+func (s *outputStats) snapshot() (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totals, s.lastError
+}
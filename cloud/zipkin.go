@@ -0,0 +1,162 @@
+package crocospans
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.k6.io/k6/output"
+)
+
+const zipkinSpansPath = "/api/v2/spans"
+
+// NewZipkin creates an output that sends the traced requests to a Zipkin
+// compatible collector, as Zipkin v2 JSON spans.
+func NewZipkin(p output.Params) (*Output, error) {
+	conf, _, err := newConfig(p, outputSpec{name: "xk6-zipkin", envPrefix: "XK6_ZIPKIN_"})
+	if err != nil {
+		return nil, err
+	}
+
+	// Allow just the collector address to be specified, e.g. http://localhost:9411
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = zipkinSpansPath
+		conf.Endpoint = u.String()
+	}
+
+	return newOutput(p, "xk6-zipkin", conf, zipkinEncoder{})
+}
+
+// zipkinSpan is a span in the Zipkin v2 format.
+//
+// Docs: https://zipkin.io/zipkin-api/#/default/post_spans
+type zipkinSpan struct {
+	TraceID        string             `json:"traceId"`
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId,omitempty"`
+	Name           string             `json:"name"`
+	Kind           string             `json:"kind"`
+	Timestamp      uint64             `json:"timestamp"`
+	Duration       uint64             `json:"duration"`
+	LocalEndpoint  *zipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint    `json:"remoteEndpoint,omitempty"`
+	Annotations    []zipkinAnnotation `json:"annotations,omitempty"`
+	Tags           map[string]string  `json:"tags"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	IPv4        string `json:"ipv4,omitempty"`
+	IPv6        string `json:"ipv6,omitempty"`
+	Port        int    `json:"port,omitempty"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp uint64 `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+// zipkinEncoder encodes spans as a Zipkin v2 JSON list.
+type zipkinEncoder struct{}
+
+func (zipkinEncoder) ContentType() string {
+	return "application/json"
+}
+
+func (zipkinEncoder) Encode(requests []*Request) ([][]byte, error) {
+	spans := make([]zipkinSpan, 0, len(requests))
+	for _, req := range requests {
+		spans = append(spans, newZipkinSpan(req))
+	}
+	batch, err := json.Marshal(spans)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{batch}, nil
+}
+
+func newZipkinSpan(req *Request) zipkinSpan {
+	tags := make(map[string]string, len(req.Tags)+6)
+	for k, v := range req.Tags {
+		tags[k] = v
+	}
+	tags["http.method"] = req.HTTPMethod
+	tags["http.status_code"] = strconv.FormatInt(req.HTTPStatus, 10)
+	tags["http.url"] = req.HTTPUrl
+	tags["k6.test_run_id"] = req.TestRunIDString
+	if req.Scenario != "" {
+		tags["k6.scenario"] = req.Scenario
+	}
+	if req.Group != "" {
+		tags["k6.group"] = req.Group
+	}
+	if req.Error != "" {
+		tags["error"] = req.Error
+	} else if !req.ExpectedResponse {
+		tags["error"] = strconv.FormatInt(req.HTTPStatus, 10)
+	}
+	// Zipkin has no span links, so redirects are recorded as a tag.
+	for _, link := range req.Links {
+		if link.Type == LinkTypeRedirect {
+			tags["k6.redirected_from"] = link.SpanID
+		}
+	}
+
+	span := zipkinSpan{
+		TraceID:        req.TraceID,
+		ID:             req.SpanID,
+		ParentID:       req.ParentSpanID,
+		Name:           req.HTTPMethod + " " + req.Name,
+		Kind:           "CLIENT",
+		Timestamp:      req.StartTimeUnixNano / 1000,
+		Duration:       (req.EndTimeUnixNano - req.StartTimeUnixNano) / 1000,
+		LocalEndpoint:  &zipkinEndpoint{ServiceName: "k6"},
+		RemoteEndpoint: newZipkinRemoteEndpoint(req.HTTPUrl),
+		Tags:           tags,
+	}
+	if req.Name == "" {
+		span.Name = req.HTTPMethod
+	}
+	for _, phase := range req.Phases {
+		span.Annotations = append(span.Annotations, zipkinAnnotation{
+			Timestamp: phase.StartTimeUnixNano / 1000,
+			Value:     phase.Name,
+		})
+	}
+	return span
+}
+
+// newZipkinRemoteEndpoint returns the remote endpoint of the request, or nil
+// if it can't be determined from the URL.
+func newZipkinRemoteEndpoint(rawURL string) *zipkinEndpoint {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+
+	endpoint := &zipkinEndpoint{}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip == nil {
+		endpoint.ServiceName = strings.ToLower(host)
+	} else if ip.To4() != nil {
+		endpoint.IPv4 = ip.String()
+	} else {
+		endpoint.IPv6 = ip.String()
+	}
+
+	switch port := u.Port(); {
+	case port != "":
+		endpoint.Port, _ = strconv.Atoi(port)
+	case u.Scheme == "https":
+		endpoint.Port = 443
+	case u.Scheme == "http":
+		endpoint.Port = 80
+	}
+	return endpoint
+}
@@ -0,0 +1,54 @@
+package crocospans
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.k6.io/k6/lib/testutils"
+	"go.k6.io/k6/metrics"
+	"go.k6.io/k6/output"
+)
+
+func TestZipkinOutput(t *testing.T) {
+	t.Parallel()
+
+	var spans []zipkinSpan
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, zipkinSpansPath, r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&spans))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	o, err := NewZipkin(output.Params{
+		ConfigArgument: srv.URL,
+		Logger:         testutils.NewLogger(t),
+	})
+	require.NoError(t, err)
+	require.NoError(t, o.Start())
+	o.AddMetricSamples([]metrics.SampleContainer{newTestTrail("abcdef")})
+	require.NoError(t, o.Stop())
+
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "abcdef", span.TraceID)
+	assert.Equal(t, "CLIENT", span.Kind)
+	assert.Equal(t, &zipkinEndpoint{ServiceName: "example.com", Port: 80}, span.RemoteEndpoint)
+	assert.Equal(t, "GET", span.Tags["http.method"])
+	assert.Equal(t, "200", span.Tags["http.status_code"])
+	assert.Equal(t, o.config.TestRunID, span.Tags["k6.test_run_id"])
+}
+
+func TestZipkinRemoteEndpoint(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, &zipkinEndpoint{IPv4: "10.0.0.1", Port: 8080}, newZipkinRemoteEndpoint("http://10.0.0.1:8080/api"))
+	assert.Equal(t, &zipkinEndpoint{IPv6: "::1", Port: 443}, newZipkinRemoteEndpoint("https://[::1]/"))
+	assert.Nil(t, newZipkinRemoteEndpoint("not a url"))
+}
@@ -0,0 +1,221 @@
+// Command xk6-spans-replay pushes span files recorded by the xk6-spans-file
+// output into any of the span exporters of the extension.
+//
+// Usage:
+//
+//	xk6-spans-replay -exporter otlp -endpoint http://localhost:4318 -rate 500 -shift-to-now spans*.ndjson.gz
+//
+// The exporters are configured with the same environment variables as the
+// corresponding k6 outputs, e.g. XK6_CROCOSPANS_ORG_ID and XK6_CROCOSPANS_TOKEN.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	crocospans "github.com/grafana/xk6-distributed-tracing/cloud"
+	"go.k6.io/k6/output"
+)
+
+var exporters = map[string]func(output.Params) (*crocospans.Output, error){
+	"crocospans": crocospans.New,
+	"jaeger":     crocospans.NewJaeger,
+	"otlp":       crocospans.NewOTLP,
+	"zipkin":     crocospans.NewZipkin,
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var (
+		exporter   = flag.String("exporter", "otlp", "the exporter to push the spans with: crocospans, jaeger, otlp or zipkin")
+		endpoint   = flag.String("endpoint", "", "the endpoint of the exporter")
+		format     = flag.String("format", "", "the format of the span files: ndjson, otlp-json or protobuf (default: guessed from the file name)")
+		spanRate   = flag.Float64("rate", 0, "the maximum number of spans pushed per second, 0 for unlimited")
+		batchSize  = flag.Int("batch-size", 100, "the number of spans pushed per request")
+		shift      = flag.Duration("shift", 0, "a duration to shift all span timestamps by")
+		shiftToNow = flag.Bool("shift-to-now", false, "shift the span timestamps so that the earliest span starts now")
+		verbose    = flag.Bool("verbose", false, "enable debug logging")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] FILE...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		return fmt.Errorf("no span files specified")
+	}
+	if *batchSize < 1 {
+		return fmt.Errorf("the batch size should be positive but was %d", *batchSize)
+	}
+
+	newExporter, ok := exporters[*exporter]
+	if !ok {
+		return fmt.Errorf("unknown exporter '%s'", *exporter)
+	}
+
+	logger := logrus.New()
+	if *verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	var requests []*crocospans.Request
+	for _, path := range flag.Args() {
+		reqs, err := readFile(path, *format)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %w", path, err)
+		}
+		logger.Debugf("Read %d spans from %s", len(reqs), path)
+		requests = append(requests, reqs...)
+	}
+	if len(requests) == 0 {
+		logger.Info("No spans to replay")
+		return nil
+	}
+
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].StartTimeUnixNano < requests[j].StartTimeUnixNano
+	})
+	offset := *shift
+	if *shiftToNow {
+		offset += time.Since(time.Unix(0, int64(requests[0].StartTimeUnixNano)))
+	}
+	shiftRequests(requests, offset)
+
+	out, err := newExporter(output.Params{
+		ConfigArgument: *endpoint,
+		Environment:    environment(),
+		Logger:         logger,
+		StdOut:         os.Stdout,
+		StdErr:         os.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+	if err := out.Start(); err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	pushed, err := pushRequests(ctx, out, requests, *batchSize, newLimiter(*spanRate, *batchSize))
+	if err != nil {
+		logger.WithError(err).Error("Failed to encode spans")
+	}
+
+	// The spans are only sent, or dropped, by the time the output is stopped.
+	stopErr := out.Stop()
+	stats := out.Stats()
+	logger.Infof("Replayed %d of %d spans with %s: %d sent, %d dropped",
+		pushed, len(requests), out.Description(), stats.SpansSent, stats.SpansDropped)
+	if stats.SpansDropped > 0 {
+		return fmt.Errorf("%d of %d spans were dropped", stats.SpansDropped, len(requests))
+	}
+	return stopErr
+}
+
+// newLimiter returns a limiter of the spans pushed per second, which allows
+// a whole batch at once. A rate of 0 is unlimited.
+func newLimiter(spanRate float64, batchSize int) *rate.Limiter {
+	if spanRate <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(spanRate), batchSize)
+}
+
+// requestAdder is the part of the exporters pushRequests uses.
+type requestAdder interface {
+	AddRequests(requests []*crocospans.Request) error
+}
+
+// pushRequests adds the requests to the exporter in batches, as fast as the
+// limiter allows, until they are all pushed or the context is done. It returns
+// the number of pushed requests.
+func pushRequests(
+	ctx context.Context, out requestAdder, requests []*crocospans.Request, batchSize int, limiter *rate.Limiter,
+) (int, error) {
+	pushed := 0
+	for pushed < len(requests) && ctx.Err() == nil {
+		end := pushed + batchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+		batch := requests[pushed:end]
+		if err := limiter.WaitN(ctx, len(batch)); err != nil {
+			break
+		}
+		if err := out.AddRequests(batch); err != nil {
+			return pushed, err
+		}
+		pushed = end
+	}
+	return pushed, nil
+}
+
+func readFile(path, format string) ([]*crocospans.Request, error) {
+	if format == "" {
+		format = guessFormat(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return crocospans.ReadSpansFile(f, format)
+}
+
+// guessFormat returns the span file format, based on the file name.
+func guessFormat(path string) string {
+	switch name := strings.ToLower(path); {
+	case strings.Contains(name, ".pb") || strings.Contains(name, ".protobuf"):
+		return crocospans.FileFormatProtobuf
+	case strings.Contains(name, "otlp"):
+		return crocospans.FileFormatOTLPJSON
+	default:
+		return crocospans.FileFormatNDJSON
+	}
+}
+
+// shiftRequests moves all timestamps of the requests by the given offset.
+func shiftRequests(requests []*crocospans.Request, offset time.Duration) {
+	if offset == 0 {
+		return
+	}
+	shiftTime := func(t uint64) uint64 {
+		return uint64(int64(t) + int64(offset))
+	}
+	for _, req := range requests {
+		req.StartTimeUnixNano = shiftTime(req.StartTimeUnixNano)
+		req.EndTimeUnixNano = shiftTime(req.EndTimeUnixNano)
+		for _, phase := range req.Phases {
+			phase.StartTimeUnixNano = shiftTime(phase.StartTimeUnixNano)
+			phase.EndTimeUnixNano = shiftTime(phase.EndTimeUnixNano)
+		}
+	}
+}
+
+func environment() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env
+}
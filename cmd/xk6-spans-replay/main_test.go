@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	crocospans "github.com/grafana/xk6-distributed-tracing/cloud"
+)
+
+func TestGuessFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"spans.ndjson":          crocospans.FileFormatNDJSON,
+		"spans.1.ndjson.gz":     crocospans.FileFormatNDJSON,
+		"spans.json":            crocospans.FileFormatNDJSON,
+		"spans.otlp.json.gz":    crocospans.FileFormatOTLPJSON,
+		"run/OTLP-spans.json":   crocospans.FileFormatOTLPJSON,
+		"spans.pb":              crocospans.FileFormatProtobuf,
+		"spans.2.pb.gz":         crocospans.FileFormatProtobuf,
+		"spans.protobuf":        crocospans.FileFormatProtobuf,
+		"/tmp/otlp/spans.pb.gz": crocospans.FileFormatProtobuf,
+	}
+	for path, format := range tests {
+		assert.Equal(t, format, guessFormat(path), path)
+	}
+}
+
+func TestShiftRequests(t *testing.T) {
+	t.Parallel()
+
+	requests := []*crocospans.Request{
+		{
+			StartTimeUnixNano: 1000,
+			EndTimeUnixNano:   3000,
+			Phases: []*crocospans.Phase{
+				{Name: "waiting", StartTimeUnixNano: 1500, EndTimeUnixNano: 2500},
+			},
+		},
+		{StartTimeUnixNano: 5000, EndTimeUnixNano: 6000},
+	}
+
+	shiftRequests(requests, time.Microsecond)
+	assert.Equal(t, uint64(2000), requests[0].StartTimeUnixNano)
+	assert.Equal(t, uint64(4000), requests[0].EndTimeUnixNano)
+	assert.Equal(t, uint64(2500), requests[0].Phases[0].StartTimeUnixNano)
+	assert.Equal(t, uint64(3500), requests[0].Phases[0].EndTimeUnixNano)
+	assert.Equal(t, uint64(6000), requests[1].StartTimeUnixNano)
+	assert.Equal(t, uint64(7000), requests[1].EndTimeUnixNano)
+
+	shiftRequests(requests, -500*time.Nanosecond)
+	assert.Equal(t, uint64(1500), requests[0].StartTimeUnixNano)
+	assert.Equal(t, uint64(3000), requests[0].Phases[0].EndTimeUnixNano)
+}
+
+type fakeExporter struct {
+	batches [][]*crocospans.Request
+	err     error
+}
+
+func (e *fakeExporter) AddRequests(requests []*crocospans.Request) error {
+	if e.err != nil {
+		return e.err
+	}
+	e.batches = append(e.batches, requests)
+	return nil
+}
+
+func newTestRequests(n int) []*crocospans.Request {
+	requests := make([]*crocospans.Request, n)
+	for i := range requests {
+		requests[i] = &crocospans.Request{}
+	}
+	return requests
+}
+
+func TestPushRequestsInBatches(t *testing.T) {
+	t.Parallel()
+
+	out := &fakeExporter{}
+	pushed, err := pushRequests(context.Background(), out, newTestRequests(25), 10, newLimiter(0, 10))
+	require.NoError(t, err)
+	assert.Equal(t, 25, pushed)
+	require.Len(t, out.batches, 3)
+	assert.Len(t, out.batches[0], 10)
+	assert.Len(t, out.batches[2], 5)
+}
+
+func TestPushRequestsIsRateLimited(t *testing.T) {
+	t.Parallel()
+
+	// The first batch is allowed right away, and the other two take 50ms
+	// each at 200 spans per second.
+	out := &fakeExporter{}
+	start := time.Now()
+	pushed, err := pushRequests(context.Background(), out, newTestRequests(30), 10, newLimiter(200, 10))
+	require.NoError(t, err)
+	assert.Equal(t, 30, pushed)
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestPushRequestsStops(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pushed, err := pushRequests(ctx, &fakeExporter{}, newTestRequests(10), 5, newLimiter(0, 5))
+	require.NoError(t, err)
+	assert.Equal(t, 0, pushed)
+
+	failing := &fakeExporter{err: errors.New("encoding failed")}
+	pushed, err = pushRequests(context.Background(), failing, newTestRequests(10), 5, newLimiter(0, 5))
+	assert.EqualError(t, err, "encoding failed")
+	assert.Equal(t, 0, pushed)
+}
@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/grafana/xk6-distributed-tracing/client"
+	"go.k6.io/k6/js/common"
+)
+
+// inject implements tracing.inject(carrier, options), which writes the trace
+// context of a new span into any object of key/value pairs, e.g. the headers
+// of a Kafka or AMQP message. The span is a child of options.parent, if set,
+// and its context is returned. With options.record, the span is also sent by
+// the outputs, as an instantaneous span named options.name.
+func (t *DistributedTracing) inject(carrier goja.Value, options goja.Value) *client.SpanContext {
+	rt := t.vu.Runtime()
+	if client.IsNilly(carrier) {
+		common.Throw(rt, fmt.Errorf("inject needs a carrier object"))
+	}
+	carrierObj := carrier.ToObject(rt)
+
+	propagator := client.PropagatorW3C
+	var parent *client.SpanContext
+	var record bool
+	var name string
+	if !client.IsNilly(options) {
+		opts := options.ToObject(rt)
+		for _, k := range opts.Keys() {
+			switch k {
+			case "propagator":
+				propagator = opts.Get(k).String()
+			case "parent":
+				ctx, err := t.exportSpanContext(opts.Get(k))
+				if err != nil {
+					common.Throw(rt, err)
+				}
+				parent = ctx
+			case "record":
+				record = opts.Get(k).ToBoolean()
+			case "name":
+				name = opts.Get(k).String()
+			default:
+				common.Throw(rt, fmt.Errorf("unknown inject option '%s'", k))
+			}
+		}
+	}
+	if name != "" && !record {
+		common.Throw(rt, fmt.Errorf("the inject name option needs record to be true"))
+	}
+	state := t.vu.State()
+	if record && state == nil {
+		common.Throw(rt, fmt.Errorf("inject can only record spans in the VU context"))
+	}
+
+	header, err := client.HeaderNameForPropagator(propagator)
+	if err != nil {
+		common.Throw(rt, err)
+	}
+	ctx, err := client.NewSpanContext(propagator, parent)
+	if err != nil {
+		common.Throw(rt, err)
+	}
+	headers, err := client.GenerateHeaderForSpanContext(ctx)
+	if err != nil {
+		common.Throw(rt, err)
+	}
+
+	// A header the carrier already has in a different case is replaced, so
+	// there's only ever one trace context in it.
+	for _, key := range carrierObj.Keys() {
+		if strings.EqualFold(key, header) {
+			_ = carrierObj.Delete(key)
+		}
+	}
+	var value interface{} = headers[header][0]
+	if propagator == client.PropagatorGRPCBin {
+		// The binary value would be mangled by a conversion to a JS string.
+		value = rt.NewArrayBuffer([]byte(headers[header][0]))
+	}
+	if err := carrierObj.Set(header, value); err != nil {
+		common.Throw(rt, err)
+	}
+
+	if record && t.injected != nil {
+		client.PushSpanSample(t.vu, state, t.injected, map[string]string{
+			"trace_id":       ctx.TraceID,
+			"span_id":        ctx.SpanID,
+			"parent_span_id": ctx.ParentSpanID,
+			"name":           name,
+		})
+	}
+	return &ctx
+}
+
+// extract implements tracing.extract(carrier, options), which reads the trace
+// context from an object of key/value pairs. It returns null if the carrier
+// has no trace context, and throws if it has one that can't be parsed.
+func (t *DistributedTracing) extract(carrier goja.Value, options goja.Value) *client.SpanContext {
+	rt := t.vu.Runtime()
+	if client.IsNilly(carrier) {
+		return nil
+	}
+
+	var propagator string
+	if !client.IsNilly(options) {
+		opts := options.ToObject(rt)
+		for _, k := range opts.Keys() {
+			switch k {
+			case "propagator":
+				propagator = opts.Get(k).String()
+			default:
+				common.Throw(rt, fmt.Errorf("unknown extract option '%s'", k))
+			}
+		}
+	}
+
+	carrierObj := carrier.ToObject(rt)
+	values := make(map[string]string, len(carrierObj.Keys()))
+	for _, key := range carrierObj.Keys() {
+		if value, ok := client.CarrierValue(carrierObj.Get(key).Export()); ok {
+			values[key] = value
+		}
+	}
+	ctx, found, err := client.ExtractTraceContext(values, propagator)
+	if err != nil {
+		common.Throw(rt, err)
+	}
+	if !found {
+		return nil
+	}
+	return &ctx
+}
+
+// exportSpanContext converts a span context passed from a script, e.g. one
+// returned by extract(), back to Go.
+func (t *DistributedTracing) exportSpanContext(val goja.Value) (*client.SpanContext, error) {
+	if client.IsNilly(val) {
+		return nil, nil
+	}
+	if ctx, ok := val.Export().(*client.SpanContext); ok {
+		return ctx, nil
+	}
+	obj := val.ToObject(t.vu.Runtime())
+	if client.IsNilly(obj.Get("trace_id")) || client.IsNilly(obj.Get("span_id")) {
+		return nil, fmt.Errorf("the parent span context needs a trace_id and a span_id")
+	}
+	ctx := &client.SpanContext{
+		TraceID: obj.Get("trace_id").String(),
+		SpanID:  obj.Get("span_id").String(),
+		Sampled: client.IsNilly(obj.Get("sampled")) || obj.Get("sampled").ToBoolean(),
+	}
+	if !ctx.IsValid() {
+		return nil, fmt.Errorf("invalid parent span context, the trace_id '%s' and span_id '%s' should be 32 and 16 lowercase hex digits, not all zeros",
+			ctx.TraceID, ctx.SpanID)
+	}
+	return ctx, nil
+}
@@ -0,0 +1,184 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/xk6-distributed-tracing/client"
+	crocospans "github.com/grafana/xk6-distributed-tracing/cloud"
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+)
+
+func newTestRuntime(t *testing.T) *modulestest.Runtime {
+	t.Helper()
+
+	rt := modulestest.NewRuntime(t)
+	m, ok := New().NewModuleInstance(rt.VU).(*DistributedTracing)
+	require.True(t, ok)
+	require.NoError(t, rt.VU.Runtime().Set("tracing", m.Exports().Named))
+	return rt
+}
+
+func TestInjectOptions(t *testing.T) {
+	t.Parallel()
+
+	rt := newTestRuntime(t)
+	val, err := rt.VU.Runtime().RunString(`
+		var w3c = {}, b3 = {};
+		var ctx = tracing.inject(w3c);
+		tracing.inject(b3, {propagator: "b3"});
+		[w3c.traceparent, ctx.trace_id, ctx.span_id, ctx.sampled, ctx.propagator, b3.b3];
+	`)
+	require.NoError(t, err)
+	var got []interface{}
+	require.NoError(t, rt.VU.Runtime().ExportTo(val, &got))
+	traceID, spanID := got[1].(string), got[2].(string)
+	assert.Equal(t, "00-"+traceID+"-"+spanID+"-01", got[0])
+	assert.Equal(t, true, got[3])
+	assert.Equal(t, client.PropagatorW3C, got[4])
+	assert.Regexp(t, "^[0-9a-f]{32}-[0-9a-f]{16}-1$", got[5])
+
+	for script, msg := range map[string]string{
+		`tracing.inject()`:                                                             "inject needs a carrier object",
+		`tracing.inject({}, {propagator: "nope"})`:                                     "unknown propagator: nope",
+		`tracing.inject({}, {header: "x"})`:                                            "unknown inject option 'header'",
+		`tracing.inject({}, {name: "produce"})`:                                        "the inject name option needs record to be true",
+		`tracing.inject({}, {record: true})`:                                           "inject can only record spans in the VU context",
+		`tracing.inject({}, {parent: {trace_id: "abc"}})`:                              "the parent span context needs a trace_id and a span_id",
+		`tracing.inject({}, {parent: {trace_id: "abc", span_id: "00f067aa0ba902b7"}})`: "invalid parent span context, the trace_id 'abc'",
+		`tracing.inject({}, {parent: {trace_id: "0AF7651916CD43DD8448EB211C80319C", span_id: "00f067aa0ba902b7"}})`: "invalid parent span context",
+		`tracing.inject({}, {parent: {trace_id: "0af7651916cd43dd8448eb211c80319c", span_id: "0000000000000000"}})`: "span_id '0000000000000000'",
+		`tracing.inject({}, {parent: {trace_id: "00000000000000000000000000000000", span_id: "00f067aa0ba902b7"}})`: "invalid parent span context",
+		`tracing.inject({}, {parent: {trace_id: "0af7651916cd43dd8448eb211c80319c", span_id: "00f067aa0ba902bz"}})`: "invalid parent span context",
+		`tracing.extract({}, {header: "x"})`:        "unknown extract option 'header'",
+		`tracing.extract({}, {propagator: "nope"})`: "unknown propagator: nope",
+		`tracing.extract({traceparent: "garbage"})`: "invalid w3c trace context",
+	} {
+		_, err := rt.VU.Runtime().RunString(script)
+		require.Error(t, err, script)
+		assert.Contains(t, err.Error(), msg, script)
+	}
+}
+
+func TestInjectReplacesTheHeaderInAnyCase(t *testing.T) {
+	t.Parallel()
+
+	rt := newTestRuntime(t)
+	val, err := rt.VU.Runtime().RunString(`
+		var carrier = {"TraceParent": "00-old", "B3": "other", "key": "value"};
+		tracing.inject(carrier);
+		Object.keys(carrier).sort().join(",");
+	`)
+	require.NoError(t, err)
+	assert.Equal(t, "B3,key,traceparent", val.String())
+}
+
+func TestInjectGRPCBinIsAnArrayBuffer(t *testing.T) {
+	t.Parallel()
+
+	rt := newTestRuntime(t)
+	val, err := rt.VU.Runtime().RunString(`
+		var carrier = {};
+		var ctx = tracing.inject(carrier, {propagator: "grpc-trace-bin"});
+		var extracted = tracing.extract(carrier);
+		[carrier["grpc-trace-bin"] instanceof ArrayBuffer, carrier["grpc-trace-bin"].byteLength,
+			extracted.trace_id === ctx.trace_id, extracted.span_id === ctx.span_id, extracted.propagator];
+	`)
+	require.NoError(t, err)
+	var got []interface{}
+	require.NoError(t, rt.VU.Runtime().ExportTo(val, &got))
+	assert.Equal(t, []interface{}{true, int64(29), true, true, client.PropagatorGRPCBin}, got)
+
+	// The context is read from byte arrays, e.g. the headers of a Kafka
+	// message, too.
+	header, err := client.GenerateHeaderBasedOnPropagator(client.PropagatorGRPCBin, "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331")
+	require.NoError(t, err)
+	require.NoError(t, rt.VU.Runtime().Set("binary", []byte(header[client.HeaderNameGRPCBin][0])))
+	val, err = rt.VU.Runtime().RunString(`tracing.extract({"Grpc-Trace-Bin": binary}).span_id`)
+	require.NoError(t, err)
+	assert.Equal(t, "b7ad6b7169203331", val.String())
+}
+
+func TestInjectWithParent(t *testing.T) {
+	t.Parallel()
+
+	rt := newTestRuntime(t)
+	val, err := rt.VU.Runtime().RunString(`
+		var received = tracing.extract({traceparent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"});
+		var forwarded = {};
+		var child = tracing.inject(forwarded, {propagator: "b3", parent: received});
+		var fromObject = tracing.inject({}, {parent: {trace_id: "0af7651916cd43dd8448eb211c80319c", span_id: "00f067aa0ba902b7"}});
+		[child.trace_id, child.parent_span_id, child.sampled, forwarded.b3 === child.trace_id + "-" + child.span_id + "-0",
+			fromObject.trace_id, fromObject.parent_span_id, fromObject.sampled];
+	`)
+	require.NoError(t, err)
+	var got []interface{}
+	require.NoError(t, rt.VU.Runtime().ExportTo(val, &got))
+	assert.Equal(t, []interface{}{
+		"0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", false, true,
+		"0af7651916cd43dd8448eb211c80319c", "00f067aa0ba902b7", true,
+	}, got)
+}
+
+func TestExtract(t *testing.T) {
+	t.Parallel()
+
+	rt := newTestRuntime(t)
+	for script, want := range map[string]interface{}{
+		`tracing.extract({"TRACEPARENT": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}).span_id`:        "b7ad6b7169203331",
+		`tracing.extract({"uber-trace-id": ["0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0:1", "x"]}).span_id`: "b7ad6b7169203331",
+		`tracing.extract({b3: "0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-1"}, {propagator: "w3c"})`:          nil,
+		`tracing.extract({"content-type": "text/plain"})`:                                                            nil,
+		`tracing.extract(null)`: nil,
+	} {
+		val, err := rt.VU.Runtime().RunString(script)
+		require.NoError(t, err, script)
+		assert.Equal(t, want, val.Export(), script)
+	}
+}
+
+func TestInjectRecordsTheSpan(t *testing.T) {
+	t.Parallel()
+
+	rt := newTestRuntime(t)
+	registry := rt.VU.InitEnv().Registry
+	samples := make(chan metrics.SampleContainer, 10)
+	rt.MoveToVUContext(&lib.State{
+		Samples:   samples,
+		Tags:      lib.NewVUStateTags(registry.RootTagSet().With("scenario", "producer")),
+		VUID:      3,
+		Iteration: 7,
+	})
+
+	val, err := rt.VU.Runtime().RunString(`
+		var parent = tracing.inject({});
+		var ctx = tracing.inject({}, {parent: parent, record: true, name: "produce orders"});
+		[parent.span_id, ctx.trace_id, ctx.span_id];
+	`)
+	require.NoError(t, err)
+	var ids []string
+	require.NoError(t, rt.VU.Runtime().ExportTo(val, &ids))
+
+	close(samples)
+	var recorded []metrics.Sample
+	for container := range samples {
+		recorded = append(recorded, container.GetSamples()...)
+	}
+	require.Len(t, recorded, 1, "only the recorded span should be sent")
+	sample := recorded[0]
+	assert.Equal(t, crocospans.MetricInjectedSpans, sample.Metric.Name)
+	assert.Equal(t, map[string]string{
+		"trace_id":       ids[1],
+		"span_id":        ids[2],
+		"parent_span_id": ids[0],
+		"name":           "produce orders",
+		"vu":             "3",
+		"iter":           "7",
+	}, sample.Metadata)
+	scenario, _ := sample.Tags.Get("scenario")
+	assert.Equal(t, "producer", scenario)
+}
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"time"
+
+	crocospans "github.com/grafana/xk6-distributed-tracing/cloud"
+	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/metrics"
+)
+
+// selfMetrics are the k6 metrics that tell how the span outputs are doing, so
+// they show up in the end-of-test summary. The outputs can't emit samples
+// themselves, so their stats are emitted by the VUs after the traced requests,
+// which means the stats of the final flush, after the test ended, are never
+// emitted. The outputs log them, and return an error from Stop if spans were
+// dropped, instead.
+type selfMetrics struct {
+	tags *metrics.TagSet
+
+	spansBuffered *metrics.Metric
+	spansSent     *metrics.Metric
+	spansDropped  *metrics.Metric
+	spansRetried  *metrics.Metric
+	pushErrors    *metrics.Metric
+	pushDuration  *metrics.Metric
+	batchSize     *metrics.Metric
+}
+
+func newSelfMetrics(registry *metrics.Registry) (*selfMetrics, error) {
+	m := &selfMetrics{tags: registry.RootTagSet()}
+	for _, def := range []struct {
+		metric *(*metrics.Metric)
+		name   string
+		typ    metrics.MetricType
+		value  metrics.ValueType
+	}{
+		{&m.spansBuffered, "tracing_spans_buffered", metrics.Counter, metrics.Default},
+		{&m.spansSent, "tracing_spans_sent", metrics.Counter, metrics.Default},
+		{&m.spansDropped, "tracing_spans_dropped", metrics.Counter, metrics.Default},
+		{&m.spansRetried, "tracing_spans_retried", metrics.Counter, metrics.Default},
+		{&m.pushErrors, "tracing_push_errors", metrics.Counter, metrics.Default},
+		{&m.pushDuration, "tracing_push_duration", metrics.Trend, metrics.Time},
+		{&m.batchSize, "tracing_batch_size", metrics.Trend, metrics.Data},
+	} {
+		metric, err := registry.NewMetric(def.name, def.typ, def.value)
+		if err != nil {
+			return nil, err
+		}
+		*def.metric = metric
+	}
+	return m, nil
+}
+
+// emit pushes the span output stats gathered since the previous call as
+// metric samples. It has to be called in the VU context.
+func (m *selfMetrics) emit(vu modules.VU) {
+	state := vu.State()
+	if state == nil {
+		return
+	}
+	stats := crocospans.DrainStats()
+
+	now := time.Now()
+	var samples metrics.Samples
+	add := func(metric *metrics.Metric, value float64) {
+		samples = append(samples, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{Metric: metric, Tags: m.tags},
+			Time:       now,
+			Value:      value,
+		})
+	}
+	for metric, count := range map[*metrics.Metric]int64{
+		m.spansBuffered: stats.SpansBuffered,
+		m.spansSent:     stats.SpansSent,
+		m.spansDropped:  stats.SpansDropped,
+		m.spansRetried:  stats.SpansRetried,
+		m.pushErrors:    stats.PushErrors,
+	} {
+		if count > 0 {
+			add(metric, float64(count))
+		}
+	}
+	for _, d := range stats.PushDurations {
+		add(m.pushDuration, metrics.D(d))
+	}
+	for _, size := range stats.BatchSizes {
+		add(m.batchSize, float64(size))
+	}
+
+	if len(samples) > 0 {
+		metrics.PushIfNotDone(vu.Context(), state.Samples, samples)
+	}
+}
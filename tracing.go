@@ -6,32 +6,63 @@ import (
 	"github.com/dop251/goja"
 	"github.com/grafana/xk6-distributed-tracing/client"
 	crocospans "github.com/grafana/xk6-distributed-tracing/cloud"
+	"github.com/sirupsen/logrus"
 	"go.k6.io/k6/js/common"
 	"go.k6.io/k6/js/modules"
+	k6grpc "go.k6.io/k6/js/modules/k6/grpc"
 	k6HTTP "go.k6.io/k6/js/modules/k6/http"
+	k6ws "go.k6.io/k6/js/modules/k6/ws"
+	"go.k6.io/k6/metrics"
 	"go.k6.io/k6/output"
 )
 
 const version = "0.2.0"
 
+// The rate at which the failed requests are logged, when they are.
+const (
+	failureLogsPerSecond = 1
+	failureLogsBurst     = 10
+)
+
 func init() {
 	modules.Register("k6/x/tracing", New())
 
 	output.RegisterExtension("xk6-crocospans", func(p output.Params) (output.Output, error) {
 		return crocospans.New(p)
 	})
+	output.RegisterExtension("xk6-zipkin", func(p output.Params) (output.Output, error) {
+		return crocospans.NewZipkin(p)
+	})
+	output.RegisterExtension("xk6-jaeger", func(p output.Params) (output.Output, error) {
+		return crocospans.NewJaeger(p)
+	})
+	output.RegisterExtension("xk6-spans-file", func(p output.Params) (output.Output, error) {
+		return crocospans.NewFile(p)
+	})
 }
 
 type (
 	// RootModule is the global module instance that will create DistributedTracing
 	// instances for each VU.
-	RootModule struct{}
+	RootModule struct {
+		// failureLogLimiter limits the logs of the failed requests of all
+		// the VUs together.
+		failureLogLimiter *client.LogLimiter
+	}
 
 	DistributedTracing struct {
 		// modules.VU provides some useful methods for accessing internal k6
 		// objects like the global context, VU state and goja runtime.
 		vu          modules.VU
 		httpRequest client.HttpRequestFunc
+		grpc        *k6grpc.ModuleInstance
+		ws          *k6ws.WS
+		selfMetrics *selfMetrics
+		wsMessages  *metrics.Metric
+		injected    *metrics.Metric
+		traced      *metrics.Metric
+
+		failureLogLimiter *client.LogLimiter
 	}
 )
 
@@ -43,19 +74,48 @@ var (
 
 // New returns a pointer to a new RootModule instance.
 func New() *RootModule {
-	return &RootModule{}
+	return &RootModule{
+		failureLogLimiter: client.NewLogLimiter(failureLogsPerSecond, failureLogsBurst),
+	}
 }
 
 // NewModuleInstance implements the modules.Module interface and returns
 // a new instance for each VU.
-func (*RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
-	r := k6HTTP.New().NewModuleInstance(vu).Exports().Default.(*goja.Object).Get("request")
+func (r *RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
+	request := k6HTTP.New().NewModuleInstance(vu).Exports().Default.(*goja.Object).Get("request")
 	var requestFunc client.HttpRequestFunc
-	err := vu.Runtime().ExportTo(r, &requestFunc)
+	err := vu.Runtime().ExportTo(request, &requestFunc)
 	if err != nil {
 		panic(err)
 	}
-	return &DistributedTracing{vu: vu, httpRequest: requestFunc}
+	grpcModule := k6grpc.New().NewModuleInstance(vu).(*k6grpc.ModuleInstance)
+	wsModule := k6ws.New().NewModuleInstance(vu).(*k6ws.WS)
+	t := &DistributedTracing{
+		vu:                vu,
+		httpRequest:       requestFunc,
+		grpc:              grpcModule,
+		ws:                wsModule,
+		failureLogLimiter: r.failureLogLimiter,
+	}
+	if env := vu.InitEnv(); env != nil && env.Registry != nil {
+		t.selfMetrics, err = newSelfMetrics(env.Registry)
+		if err != nil {
+			panic(err)
+		}
+		t.wsMessages, err = env.Registry.NewMetric(crocospans.MetricWSMessages, metrics.Counter)
+		if err != nil {
+			panic(err)
+		}
+		t.injected, err = env.Registry.NewMetric(crocospans.MetricInjectedSpans, metrics.Counter)
+		if err != nil {
+			panic(err)
+		}
+		t.traced, err = env.Registry.NewMetric("tracing_traced_requests", metrics.Counter)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return t
 }
 
 // Exports implements the modules.Instance interface and returns the exports
@@ -63,8 +123,12 @@ func (*RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
 func (c *DistributedTracing) Exports() modules.Exports {
 	return modules.Exports{
 		Named: map[string]interface{}{
-			"Http":    c.http,
-			"version": version,
+			"Http":      c.http,
+			"Client":    c.grpcClient,
+			"WebSocket": c.wsClient,
+			"inject":    c.inject,
+			"extract":   c.extract,
+			"version":   version,
 		},
 	}
 }
@@ -72,7 +136,8 @@ func (c *DistributedTracing) Exports() modules.Exports {
 func (t *DistributedTracing) parseClientOptions(val goja.Value) (client.Options, error) {
 	rt := t.vu.Runtime()
 	opts := client.Options{
-		Propagator: client.PropagatorW3C,
+		Propagator:           client.PropagatorW3C,
+		TracedRequestsMetric: t.traced,
 	}
 
 	if val == nil || goja.IsUndefined(val) || goja.IsNull(val) {
@@ -85,19 +150,170 @@ func (t *DistributedTracing) parseClientOptions(val goja.Value) (client.Options,
 		case "propagator":
 			opts.Propagator = params.Get(k).ToString().String()
 			//TODO: validate
+		case "promoteTags":
+			if err := rt.ExportTo(params.Get(k), &opts.PromotedTags); err != nil {
+				return opts, fmt.Errorf("promoteTags should be an array of tag names: %w", err)
+			}
+			if err := client.ValidatePromotedTags(opts.PromotedTags); err != nil {
+				return opts, err
+			}
+		case "headerMode":
+			opts.HeaderMode = params.Get(k).String()
+			if err := client.ValidateHeaderMode(opts.HeaderMode); err != nil {
+				return opts, err
+			}
 		default:
-			return opts, fmt.Errorf("unknown HTTP tracing option '%s'", k)
+			return opts, fmt.Errorf("unknown tracing option '%s'", k)
 		}
 	}
 	return opts, nil
 }
 
+func (t *DistributedTracing) parseHTTPOptions(val goja.Value) (client.Options, error) {
+	rt := t.vu.Runtime()
+	var logFailures *client.FailureLog
+	var traceURL string
+
+	// The HTTP specific options are taken out, and the rest are the common
+	// tracing options.
+	rest := rt.NewObject()
+	if val != nil && !goja.IsUndefined(val) && !goja.IsNull(val) {
+		params := val.ToObject(rt)
+		for _, k := range params.Keys() {
+			switch k {
+			case "logFailures":
+				level, err := parseLogFailures(params.Get(k))
+				if err != nil {
+					return client.Options{}, err
+				}
+				if level != nil {
+					logFailures = &client.FailureLog{Level: *level, Limiter: t.failureLogLimiter}
+				}
+			case "traceURL":
+				traceURL = params.Get(k).String()
+			default:
+				if err := rest.Set(k, params.Get(k)); err != nil {
+					return client.Options{}, err
+				}
+			}
+		}
+	}
+	if logFailures != nil {
+		logFailures.TraceURL = traceURL
+	}
+
+	opts, err := t.parseClientOptions(rest)
+	opts.LogFailures = logFailures
+	return opts, err
+}
+
+// parseLogFailures parses the logFailures option, which is either a boolean,
+// to log the failures at the info level, or the name of a log level.
+func parseLogFailures(val goja.Value) (*logrus.Level, error) {
+	var level logrus.Level
+	switch v := val.Export().(type) {
+	case bool:
+		if !v {
+			return nil, nil
+		}
+		level = logrus.InfoLevel
+	case string:
+		var err error
+		if level, err = logrus.ParseLevel(v); err != nil {
+			return nil, fmt.Errorf("invalid logFailures level: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("logFailures should be a boolean or a log level, like \"warn\"")
+	}
+	return &level, nil
+}
+
 func (t *DistributedTracing) http(call goja.ConstructorCall) *goja.Object {
 	rt := t.vu.Runtime()
-	opts, err := t.parseClientOptions(call.Argument(0))
+	opts, err := t.parseHTTPOptions(call.Argument(0))
 	if err != nil {
 		common.Throw(rt, err)
 	}
 
+	if opts.Propagator == client.PropagatorGRPCBin {
+		common.Throw(rt, fmt.Errorf("the %s propagator can only be used with gRPC", opts.Propagator))
+	}
+	if t.selfMetrics != nil {
+		opts.AfterRequest = func() { t.selfMetrics.emit(t.vu) }
+	}
 	return rt.ToValue(client.New(t.vu, t.httpRequest, opts)).ToObject(rt)
 }
+
+func (t *DistributedTracing) grpcClient(call goja.ConstructorCall) *goja.Object {
+	rt := t.vu.Runtime()
+	opts, err := t.parseClientOptions(call.Argument(0))
+	if err != nil {
+		common.Throw(rt, err)
+	}
+	if t.selfMetrics != nil {
+		opts.AfterRequest = func() { t.selfMetrics.emit(t.vu) }
+	}
+
+	grpcClient, ok := t.grpc.NewClient(goja.ConstructorCall{}).Export().(*k6grpc.Client)
+	if !ok {
+		common.Throw(rt, fmt.Errorf("unexpected k6/net/grpc client type"))
+	}
+	return rt.ToValue(client.NewGRPC(t.vu, grpcClient, opts)).ToObject(rt)
+}
+
+func (t *DistributedTracing) parseWSOptions(val goja.Value) (client.WSOptions, error) {
+	rt := t.vu.Runtime()
+	opts := client.WSOptions{
+		DataField:     "data",
+		MessageMetric: t.wsMessages,
+	}
+
+	// The WebSocket specific options are taken out, and the rest are the
+	// common tracing options.
+	rest := rt.NewObject()
+	if val != nil && !goja.IsUndefined(val) && !goja.IsNull(val) {
+		params := val.ToObject(rt)
+		for _, k := range params.Keys() {
+			switch k {
+			case "messageSpans":
+				opts.MessageSpans = params.Get(k).ToBoolean()
+			case "envelope":
+				envelope := params.Get(k).ToObject(rt)
+				for _, ek := range envelope.Keys() {
+					switch ek {
+					case "traceField":
+						opts.TraceField = envelope.Get(ek).String()
+					case "dataField":
+						opts.DataField = envelope.Get(ek).String()
+					default:
+						return opts, fmt.Errorf("unknown WebSocket envelope option '%s'", ek)
+					}
+				}
+			default:
+				if err := rest.Set(k, params.Get(k)); err != nil {
+					return opts, err
+				}
+			}
+		}
+	}
+
+	var err error
+	opts.Options, err = t.parseClientOptions(rest)
+	return opts, err
+}
+
+func (t *DistributedTracing) wsClient(call goja.ConstructorCall) *goja.Object {
+	rt := t.vu.Runtime()
+	opts, err := t.parseWSOptions(call.Argument(0))
+	if err != nil {
+		common.Throw(rt, err)
+	}
+	if opts.Propagator == client.PropagatorGRPCBin {
+		common.Throw(rt, fmt.Errorf("the %s propagator can only be used with gRPC", opts.Propagator))
+	}
+	if t.selfMetrics != nil {
+		opts.AfterRequest = func() { t.selfMetrics.emit(t.vu) }
+	}
+
+	return rt.ToValue(client.NewWS(t.vu, t.ws, opts)).ToObject(rt)
+}